@@ -0,0 +1,82 @@
+package graphite
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Metric is a single data point of Graphite plaintext protocol
+type Metric struct {
+	Timestamp   int64
+	MetricName  string
+	MetricValue float64
+	Tags        map[string]string
+}
+
+// tagValueReplacer replaces characters which break plaintext line or tag list
+var tagValueReplacer = strings.NewReplacer(";", "_", " ", "_", "\t", "_", "\n", "_")
+
+// TagValue returns value usable as Graphite tag value
+func TagValue(value string) string {
+	value = tagValueReplacer.Replace(value)
+	if value == "" {
+		return "_"
+	}
+	if strings.HasPrefix(value, "~") {
+		value = "_" + value[1:]
+	}
+	return value
+}
+
+// MetricToString returns metric as plaintext protocol line, tags are sorted by name:
+// 'local.random.diceroll 4 1600000000' OR 'disk.used;datacenter=dc1;rack=a1 42 1600000000'
+func MetricToString(metric Metric, enableTags bool) string {
+	graphiteMetric := bytes.NewBuffer([]byte(""))
+
+	graphiteMetric.WriteString(metric.MetricName)
+
+	if enableTags {
+		tagNames := make([]string, 0, len(metric.Tags))
+		for tagName := range metric.Tags {
+			tagNames = append(tagNames, tagName)
+		}
+		sort.Strings(tagNames)
+		for _, tagName := range tagNames {
+			graphiteMetric.WriteString(";")
+			graphiteMetric.WriteString(tagName)
+			graphiteMetric.WriteString("=")
+			graphiteMetric.WriteString(TagValue(metric.Tags[tagName]))
+		}
+	}
+	graphiteMetric.WriteString(" ")
+	graphiteMetric.WriteString(strconv.FormatFloat(metric.MetricValue, 'f', -1, 64))
+	graphiteMetric.WriteString(" ")
+	graphiteMetric.WriteString(strconv.FormatInt(metric.Timestamp, 10))
+	graphiteMetric.WriteString("\n")
+	return graphiteMetric.String()
+}
+
+// Send writes metrics to carbon plaintext receiver, e.g. localhost:2003
+func Send(addr string, metrics []Metric, enableTags bool) error {
+	conn, err := net.DialTimeout("tcp", addr, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	lines := bytes.NewBuffer([]byte(""))
+	for _, metric := range metrics {
+		lines.WriteString(MetricToString(metric, enableTags))
+	}
+	conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	_, err = conn.Write(lines.Bytes())
+	if err != nil {
+		return fmt.Errorf("Cant send metrics to %s: %s", addr, err)
+	}
+	return nil
+}
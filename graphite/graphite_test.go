@@ -0,0 +1,21 @@
+package graphite
+
+import (
+	"testing"
+)
+
+func TestMetricToString(t *testing.T) {
+	metric := Metric{1600000000, "disk.used", 42, map[string]string{"rack": "a1", "datacenter": "dc1"}}
+
+	if s := MetricToString(metric, true); s != "disk.used;datacenter=dc1;rack=a1 42 1600000000\n" {
+		t.Errorf("Not expected line with tags: '%s'", s)
+	}
+	if s := MetricToString(metric, false); s != "disk.used 42 1600000000\n" {
+		t.Errorf("Not expected line without tags: '%s'", s)
+	}
+
+	metric = Metric{1600000000, "latency", 1.25, map[string]string{"rule": "~sum(%s; a) [1h]", "empty": ""}}
+	if s := MetricToString(metric, true); s != "latency;empty=_;rule=_sum(%s__a)_[1h] 1.25 1600000000\n" {
+		t.Errorf("Not expected line with escaped tags: '%s'", s)
+	}
+}
@@ -13,6 +13,8 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/ifireice/metric_reader/graphite"
 )
 
 type metric struct {
@@ -211,30 +213,12 @@ func generateMetricValue () (metricValue int64) {
 func metricToString(metric metric, enableTags bool) string {
 	//echo "local.random.diceroll 4 `date +%s`" | nc localhost 2003
 	///echo "disk.used;datacenter=dc1;rack=a1;server=web01 42 `date +%s`" | nc localhost 2003
-	graphiteMetric := bytes.NewBuffer([]byte(""))
-
-	graphiteMetric.WriteString(metric.MetricName)
-
-	if enableTags {
-		graphiteMetric.WriteString(";")
-		countTags := len(metric.Tags)
-		index := 0
-		for tagName, tagValue := range metric.Tags {
-			index++
-			graphiteMetric.WriteString(tagName)
-			graphiteMetric.WriteString("=")
-			graphiteMetric.WriteString(tagValue)
-			if index < countTags {
-				graphiteMetric.WriteString(";")
-			}
-		}
-	}
-	graphiteMetric.WriteString(" ")
-	graphiteMetric.WriteString(strconv.FormatInt(metric.MetricValue,10))
-	graphiteMetric.WriteString(" ")
-	graphiteMetric.WriteString(strconv.FormatInt(metric.Timestamp, 10))
-	graphiteMetric.WriteString("\n")
-	return graphiteMetric.String()
+	return graphite.MetricToString(graphite.Metric{
+		Timestamp:   metric.Timestamp,
+		MetricName:  metric.MetricName,
+		MetricValue: float64(metric.MetricValue),
+		Tags:        metric.Tags,
+	}, enableTags)
 }
 
 func daysToHours(dateString string) (dateStringHours string, err error){
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ifireice/metric_reader/metric_reader/carbon"
+	"github.com/ifireice/metric_reader/metric_reader/prometheus"
+)
+
+// Replay formats: record written by -record, access log of render requests
+// OR Prometheus query log
+const (
+	RecordReplay      = "record"
+	CarbonapiReplay   = "carbonapi"
+	GraphiteWebReplay = "graphite-web"
+	NginxReplay       = "nginx"
+	PrometheusReplay  = "prometheus"
+)
+
+// combinedRe matches time and request of combined log format used by nginx and graphite-web
+// under gunicorn OR apache, e.g. '1.2.3.4 - - [01/Sep/2020:12:00:00 +0000] "GET /render/?target=a HTTP/1.1" 200 ...'
+var combinedRe = regexp.MustCompile(`\[([^\]]+)\] "([A-Z]+) ([^ "]+)[^"]*"`)
+
+const combinedTime = "02/Jan/2006:15:04:05 -0700"
+
+// carbonapiEntry is a part of carbonapi JSON access log record
+type carbonapiEntry struct {
+	Timestamp json.RawMessage `json:"timestamp"`
+	TS        json.RawMessage `json:"ts"`
+	URL       string          `json:"url"`
+	URI       string          `json:"uri"`
+}
+
+// queryLogEntry is a part of Prometheus query log record, step and timings are in seconds.
+// Records of API queries have HTTPRequest, records of rule evaluations have RuleGroup
+type queryLogEntry struct {
+	HTTPRequest *struct {
+		Path string `json:"path"`
+	} `json:"httpRequest"`
+	RuleGroup *struct {
+		Name string `json:"name"`
+	} `json:"ruleGroup"`
+	Params struct {
+		Query string    `json:"query"`
+		Start time.Time `json:"start"`
+		End   time.Time `json:"end"`
+		Step  float64   `json:"step"`
+	} `json:"params"`
+	Stats struct {
+		Timings struct {
+			EvalTotalTime float64 `json:"evalTotalTime"`
+			ExecTotalTime float64 `json:"execTotalTime"`
+		} `json:"timings"`
+	} `json:"stats"`
+	TS time.Time `json:"ts"`
+}
+
+// ReplaySource returns name of backend which accepts requests of replay format, empty if any
+func ReplaySource(format string) string {
+	switch format {
+	case CarbonapiReplay, GraphiteWebReplay, NginxReplay:
+		return carbon.Name
+	case PrometheusReplay:
+		return prometheus.Name
+	}
+	return ""
+}
+
+// logRequest is a request found in access log
+type logRequest struct {
+	At    time.Time
+	Entry RecordEntry
+}
+
+// ReadAccessLog reads requests from access log of format. Replayed requests cover the same ranges
+// relative to now: absolute from and until of render requests are replaced by offsets from request time,
+// ranges of Prometheus queries are shifted when sent. Prometheus rule evaluations are skipped
+// unless ruleEvals is set, as the target evaluates rules itself.
+// Returns requests sorted by time and number of skipped lines, e.g. not render requests
+func ReadAccessLog(path string, format string, ruleEvals bool) ([]RecordEntry, int, error) {
+	if ReplaySource(format) == "" {
+		return nil, 0, fmt.Errorf("Unknown replay format '%s'", format)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer file.Close()
+
+	requests := make([]logRequest, 0)
+	skipped := 0
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		at, entry, err := parseLogLine(scanner.Bytes(), format, ruleEvals)
+		if err != nil {
+			skipped++
+			continue
+		}
+		requests = append(requests, logRequest{at, entry})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, skipped, err
+	}
+	if len(requests) == 0 {
+		return nil, skipped, fmt.Errorf("Access log '%s' has no requests to replay", path)
+	}
+
+	sort.SliceStable(requests, func(i, j int) bool { return requests[i].At.Before(requests[j].At) })
+	records := make([]RecordEntry, 0, len(requests))
+	for _, r := range requests {
+		r.Entry.OffsetMs = toMs(r.At.Sub(requests[0].At))
+		records = append(records, r.Entry)
+	}
+	return records, skipped, nil
+}
+
+// parseLogLine returns time and record of request logged in line
+func parseLogLine(line []byte, format string, ruleEvals bool) (time.Time, RecordEntry, error) {
+	if format == PrometheusReplay {
+		return parseQueryLogLine(line, ruleEvals)
+	}
+
+	parse := parseCombinedLine
+	if format == CarbonapiReplay {
+		parse = parseCarbonapiLine
+	}
+	at, uri, err := parse(line)
+	if err != nil {
+		return at, RecordEntry{}, err
+	}
+	entry, err := renderRecord(at, uri, format)
+	return at, entry, err
+}
+
+func parseQueryLogLine(line []byte, ruleEvals bool) (time.Time, RecordEntry, error) {
+	var entry queryLogEntry
+	err := json.Unmarshal(line, &entry)
+	if err != nil {
+		return time.Time{}, RecordEntry{}, err
+	}
+	if entry.Params.Query == "" || entry.TS.IsZero() {
+		return time.Time{}, RecordEntry{}, fmt.Errorf("Not a query log line: %.100s", line)
+	}
+	if entry.HTTPRequest == nil && !ruleEvals {
+		return time.Time{}, RecordEntry{}, fmt.Errorf("Not an API query: %.100s", line)
+	}
+	rule := PrometheusReplay
+	if entry.RuleGroup != nil {
+		rule = PrometheusReplay + " rules"
+	}
+
+	// exec time includes waiting in query queue
+	logged := entry.Stats.Timings.ExecTotalTime
+	if logged <= 0 {
+		logged = entry.Stats.Timings.EvalTotalTime
+	}
+	step := time.Duration(entry.Params.Step * float64(time.Second))
+	return entry.TS, RecordEntry{
+		Method:   http.MethodGet,
+		Rule:     rule,
+		Query:    entry.Params.Query,
+		From:     entry.Params.Start,
+		Until:    entry.Params.End,
+		Range:    step > 0,
+		StepMs:   toMs(step),
+		Time:     entry.TS,
+		LoggedMs: logged * 1000,
+	}, nil
+}
+
+func parseCombinedLine(line []byte) (time.Time, string, error) {
+	m := combinedRe.FindSubmatch(line)
+	if m == nil {
+		return time.Time{}, "", fmt.Errorf("Cant parse access log line: %.100s", line)
+	}
+	if string(m[2]) != http.MethodGet {
+		return time.Time{}, "", fmt.Errorf("Render parameters of %s request are not logged", m[2])
+	}
+	at, err := time.Parse(combinedTime, string(m[1]))
+	return at, string(m[3]), err
+}
+
+func parseCarbonapiLine(line []byte) (time.Time, string, error) {
+	var entry carbonapiEntry
+	err := json.Unmarshal(line, &entry)
+	if err != nil {
+		return time.Time{}, "", err
+	}
+
+	uri := entry.URL
+	if uri == "" {
+		uri = entry.URI
+	}
+	// full URL is logged by some versions
+	if u, err := url.Parse(uri); err == nil && u.IsAbs() {
+		uri = u.RequestURI()
+	}
+
+	ts := entry.Timestamp
+	if len(ts) == 0 {
+		ts = entry.TS
+	}
+	at, err := parseLogTime(ts)
+	return at, uri, err
+}
+
+// parseLogTime parses JSON timestamp: ISO 8601 string OR unix time in seconds
+func parseLogTime(raw json.RawMessage) (time.Time, error) {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05.000Z0700"} {
+			if t, err := time.Parse(layout, s); err == nil {
+				return t, nil
+			}
+		}
+		return time.Time{}, fmt.Errorf("Cant parse timestamp '%s'", s)
+	}
+
+	var f float64
+	if err := json.Unmarshal(raw, &f); err != nil {
+		return time.Time{}, fmt.Errorf("Cant parse timestamp '%s'", raw)
+	}
+	sec := int64(f)
+	return time.Unix(sec, int64((f-float64(sec))*1e9)), nil
+}
+
+// renderRecord returns record of render request made at time at
+func renderRecord(at time.Time, uri string, rule string) (RecordEntry, error) {
+	u, err := url.ParseRequestURI(uri)
+	if err != nil {
+		return RecordEntry{}, err
+	}
+	if !strings.HasSuffix(strings.TrimSuffix(u.Path, "/"), "/render") {
+		return RecordEntry{}, fmt.Errorf("Not a render request: %s", uri)
+	}
+	params := u.Query()
+	targets := params["target"]
+	if len(targets) == 0 {
+		return RecordEntry{}, fmt.Errorf("No targets in render request: %s", uri)
+	}
+
+	loc := time.UTC
+	if tz := params.Get("tz"); tz != "" {
+		if l, err := time.LoadLocation(tz); err == nil {
+			loc = l
+		}
+	}
+	// graphite-web defaults
+	from, until := at.Add(-24*time.Hour), at
+	if value := params.Get("from"); value != "" {
+		if t, err := carbon.ParseTime(value, at, loc); err == nil {
+			from = t
+			params.Set("from", carbon.RelativeTime(t, at))
+		}
+	}
+	if value := params.Get("until"); value != "" {
+		if t, err := carbon.ParseTime(value, at, loc); err == nil {
+			until = t
+			params.Set("until", carbon.RelativeTime(t, at))
+		}
+	}
+
+	format := params.Get("format")
+	if format == "" {
+		format = "png"
+	}
+	maxDataPoints, _ := strconv.Atoi(params.Get("maxDataPoints"))
+
+	u.RawQuery = params.Encode()
+	return RecordEntry{
+		Method:        http.MethodGet,
+		URL:           u.RequestURI(),
+		Rule:          rule,
+		Query:         strings.Join(targets, "; "),
+		From:          from,
+		Until:         until,
+		MaxDataPoints: maxDataPoints,
+		Format:        format,
+	}, nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ifireice/metric_reader/metric_reader/prometheus"
+)
+
+func TestReadAccessLog(t *testing.T) {
+	for format, lines := range map[string]string{
+		CarbonapiReplay: `{"level":"INFO","timestamp":"2020-09-01T12:00:10.000Z","logger":"access","handler":"render","url":"/render/?target=sumSeries(a.*)&from=1598957410&until=1598961010&format=json&maxDataPoints=500","http_code":200}
+{"level":"INFO","ts":1598961600.5,"logger":"access","handler":"find","url":"/metrics/find/?query=a.*"}
+{"level":"INFO","timestamp":"2020-09-01T12:00:00.000Z","logger":"access","handler":"render","url":"http://graphite/render?target=a.b&target=a.c&from=-1h"}
+not json`,
+		NginxReplay: `10.0.0.1 - - [01/Sep/2020:12:00:10 +0000] "GET /render/?target=sumSeries(a.*)&from=1598957410&until=1598961010&format=json&maxDataPoints=500 HTTP/1.1" 200 512 "-" "Grafana/7.1"
+10.0.0.1 - - [01/Sep/2020:12:00:05 +0000] "POST /render HTTP/1.1" 200 512 "-" "Grafana/7.1"
+10.0.0.1 - - [01/Sep/2020:12:00:06 +0000] "GET /metrics/find?query=a.* HTTP/1.1" 200 64 "-" "Grafana/7.1"
+10.0.0.1 - - [01/Sep/2020:12:00:00 +0000] "GET /render?target=a.b&target=a.c&from=-1h HTTP/1.1" 200 512 "-" "curl/7.68"`,
+	} {
+		path := filepath.Join(t.TempDir(), "access.log")
+		if err := ioutil.WriteFile(path, []byte(lines), 0644); err != nil {
+			t.Fatal(err)
+		}
+		records, skipped, err := ReadAccessLog(path, format, false)
+		if err != nil {
+			t.Fatalf("%s: %s", format, err)
+		}
+		if len(records) != 2 || skipped != 2 {
+			t.Fatalf("%s: not expected records: %+v, skipped: %d", format, records, skipped)
+		}
+
+		// relative range is kept, default format of graphite-web is png
+		first := records[0]
+		if first.OffsetMs != 0 || first.Query != "a.b; a.c" || first.Format != "png" || first.Until.Sub(first.From) != time.Hour {
+			t.Errorf("%s: not expected record: %+v", format, first)
+		}
+
+		// absolute range ends 10 minutes before request
+		second := records[1]
+		u, _ := url.ParseRequestURI(second.URL)
+		params := u.Query()
+		if second.Offset() != 10*time.Second || params.Get("from") != "-4200s" || params.Get("until") != "-600s" || params.Get("target") != "sumSeries(a.*)" {
+			t.Errorf("%s: not expected record: %+v", format, second)
+		}
+		if second.MaxDataPoints != 500 || second.Format != "json" || second.Rule != format {
+			t.Errorf("%s: not expected record: %+v", format, second)
+		}
+
+		request := second.Request("http://localhost:8080")
+		if request.URL != "http://localhost:8080"+second.URL {
+			t.Errorf("%s: not expected URL: %s", format, request.URL)
+		}
+	}
+
+	if _, _, err := ReadAccessLog("access.log", "apache", false); err == nil {
+		t.Error("Error should not be nil for unknown format")
+	}
+}
+
+func TestReplayQueryLog(t *testing.T) {
+	lines := `{"httpRequest":{"clientIP":"10.0.0.1","method":"GET","path":"/api/v1/query_range"},"params":{"end":"2020-09-01T12:00:00.000Z","query":"rate(up[5m])","start":"2020-09-01T11:00:00.000Z","step":15},"stats":{"timings":{"evalTotalTime":0.01,"execQueueTime":0.001,"execTotalTime":0.012}},"ts":"2020-09-01T12:00:30.000Z"}
+{"params":{"end":"2020-09-01T12:00:00.000Z","query":"up","start":"2020-09-01T12:00:00.000Z","step":0},"ruleGroup":{"file":"rules.yml","name":"up"},"stats":{"timings":{"evalTotalTime":0.002}},"ts":"2020-09-01T12:00:00.000Z"}
+{"level":"info","msg":"not a query"}`
+	path := filepath.Join(t.TempDir(), "queries.log")
+	if err := ioutil.WriteFile(path, []byte(lines), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// rule evaluations are skipped by default
+	records, skipped, err := ReadAccessLog(path, PrometheusReplay, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || skipped != 2 || records[0].Query != "rate(up[5m])" {
+		t.Fatalf("Not expected records: %+v, skipped: %d", records, skipped)
+	}
+
+	records, skipped, err = ReadAccessLog(path, PrometheusReplay, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || skipped != 1 {
+		t.Fatalf("Not expected records: %+v, skipped: %d", records, skipped)
+	}
+	if records[0].Query != "up" || records[0].Range || records[0].LoggedMs != 2 || records[0].Rule != PrometheusReplay+" rules" {
+		t.Errorf("Not expected instant query record: %+v", records[0])
+	}
+	if records[1].Offset() != 30*time.Second || !records[1].Range || records[1].StepMs != 15000 || records[1].LoggedMs != 12 {
+		t.Errorf("Not expected range query record: %+v", records[1])
+	}
+
+	// range ends 30 seconds before send time as in the log
+	b := prometheus.New("http://localhost:9090")
+	out := make(chan requestData, 2)
+	done := make(chan bool, 1)
+	replayRequests(context.Background(), NewLoadProfile(nil, 0, 1), b, records, "http://localhost:9090", 0, 0, out, nil, done)
+	<-done
+	<-out
+	request := <-out
+	if ago := time.Since(request.Until); ago < 29*time.Second || ago > 32*time.Second || request.Until.Sub(request.From) != time.Hour {
+		t.Errorf("Not expected shifted range: %s - %s", request.From, request.Until)
+	}
+	if request.URL != b.BuildRequest(request.Query).URL || request.Logged != 12*time.Millisecond {
+		t.Errorf("Not expected replayed request: %+v", request)
+	}
+
+	summary := NewSummary(10)
+	request.Elapsed = 20 * time.Millisecond
+	request.Status = "ok"
+	summary.Add(request)
+	if summary.Replay[ReplayObserved].Latency.Max() != request.Elapsed || summary.Replay[ReplayLogged].Latency.Max() != request.Logged {
+		t.Errorf("Not expected replay stats: %+v", summary.Replay)
+	}
+	if overhead := summary.Replay[ReplayOverhead].Latency.Max(); overhead != 8*time.Millisecond {
+		t.Errorf("Not expected replay overhead: %s", overhead)
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Analyze groupings
+const (
+	GroupByRule     = "rule"
+	GroupByQuery    = "query"
+	GroupByStage    = "stage"
+	GroupByStatus   = "status"
+	GroupByTagCount = "tags"
+	GroupByPeriod   = "period"
+	GroupByCache    = "cache"
+)
+
+// LogFilter selects request log entries
+type LogFilter struct {
+	Rule     *regexp.Regexp
+	From     time.Time
+	Until    time.Time
+	Statuses map[string]bool
+}
+
+// Match returns true if entry passes filter
+func (f *LogFilter) Match(entry LogEntry) bool {
+	if f.Rule != nil && !f.Rule.MatchString(entry.Rule) {
+		return false
+	}
+	if !f.From.IsZero() && entry.Time.Before(f.From) {
+		return false
+	}
+	if !f.Until.IsZero() && entry.Time.After(f.Until) {
+		return false
+	}
+	if len(f.Statuses) > 0 && !f.Statuses[entry.Status] {
+		return false
+	}
+	return true
+}
+
+// ReadLog calls f for every entry of NDJSON request log passing filter
+func ReadLog(path string, filter *LogFilter, f func(LogEntry)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var entry LogEntry
+		err := json.Unmarshal(scanner.Bytes(), &entry)
+		if err != nil {
+			if isSummaryLine(scanner.Bytes()) {
+				return fmt.Errorf("%s is a run summary (-output), request log written by -log is expected", path)
+			}
+			return fmt.Errorf("%s:%d: %s", path, line, err)
+		}
+		if filter.Match(entry) {
+			f(entry)
+		}
+	}
+	return scanner.Err()
+}
+
+// isSummaryLine returns true for the first line of JSON OR CSV summary written by WriteSummary
+func isSummaryLine(line []byte) bool {
+	s := strings.TrimSpace(string(line))
+	return s == "{" || strings.HasPrefix(s, "# version:")
+}
+
+func fromMs(ms float64) time.Duration {
+	return time.Duration(ms * float64(time.Millisecond))
+}
+
+// Request returns request data restored from log entry
+func (e LogEntry) Request() requestData {
+	var request requestData
+	request.URL = e.URL
+	request.MetricName = e.Query
+	request.Rule = e.Rule
+	request.Stage = e.Stage
+	request.Cache = e.Cache
+	request.From = e.From
+	request.Until = e.Until
+	request.Scheduled = e.Time
+	request.Elapsed = fromMs(e.ElapsedMs)
+	request.Logged = fromMs(e.LoggedMs)
+	request.Status = e.Status
+	request.StatusCode = e.StatusCode
+	request.Error = e.Error
+	request.Failed = e.Failed
+	request.Late = e.Late
+	request.Dropped = e.Dropped
+	request.Bytes = e.Bytes
+	request.Timings = Timings{
+		Wait:     fromMs(e.PhasesMs[PhaseWait]),
+		DNS:      fromMs(e.PhasesMs[PhaseDNS]),
+		Connect:  fromMs(e.PhasesMs[PhaseConnect]),
+		TLS:      fromMs(e.PhasesMs[PhaseTLS]),
+		TTFB:     fromMs(e.PhasesMs[PhaseTTFB]),
+		Download: fromMs(e.PhasesMs[PhaseDownload]),
+	}
+	return request
+}
+
+// tagCount returns number of tag or label matchers in query
+func tagCount(query string) int {
+	return strings.Count(query, "=")
+}
+
+// GroupKey returns entry group name for groupBy
+func GroupKey(entry LogEntry, groupBy string) (string, error) {
+	switch groupBy {
+	case GroupByRule:
+		return entry.Rule, nil
+	case GroupByQuery:
+		return entry.Query, nil
+	case GroupByStage:
+		return entry.Stage, nil
+	case GroupByStatus:
+		return entry.Status, nil
+	case GroupByTagCount:
+		return fmt.Sprintf("%03d", tagCount(entry.Query)), nil
+	case GroupByCache:
+		return entry.Cache, nil
+	case GroupByPeriod:
+		return entry.Until.Sub(entry.From).String(), nil
+	}
+	return "", fmt.Errorf("Unknown grouping '%s'", groupBy)
+}
+
+// Analysis is stats of request log entries, total and by group
+type Analysis struct {
+	GroupBy string
+	Total   *Stats
+	Groups  map[string]*Stats
+}
+
+// Analyze reads request logs and aggregates entries passing filter
+func Analyze(paths []string, filter *LogFilter, groupBy string) (*Analysis, error) {
+	if _, err := GroupKey(LogEntry{}, groupBy); err != nil {
+		return nil, err
+	}
+
+	analysis := &Analysis{groupBy, NewStats(), make(map[string]*Stats)}
+	// stages are time slices, other groups are spread over the run
+	run := analysis.Total
+	if groupBy == GroupByStage {
+		run = nil
+	}
+	for _, path := range paths {
+		err := ReadLog(path, filter, func(entry LogEntry) {
+			if entry.Stage == warmupStage {
+				return
+			}
+			request := entry.Request()
+			key, _ := GroupKey(entry, groupBy)
+			analysis.Total.Add(request)
+			getStats(analysis.Groups, key, run).Add(request)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return analysis, nil
+}
+
+// Print writes human readable analysis
+func (a *Analysis) Print(w io.Writer) {
+	printStatsHeader(w)
+	printStats(w, "total", "", a.Total)
+	for _, k := range sortedKeys(a.Groups) {
+		printStats(w, a.GroupBy, k, a.Groups[k])
+	}
+}
+
+// WriteJSON writes analysis as JSON document
+func (a *Analysis) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(struct {
+		GroupBy string                 `json:"group_by"`
+		Total   StatsReport            `json:"total"`
+		Groups  map[string]StatsReport `json:"groups"`
+	}{a.GroupBy, NewStatsReport(a.Total), statsReports(a.Groups)})
+}
+
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// runAnalyze is 'analyze' subcommand: recomputes stats from request logs
+func runAnalyze(args []string) error {
+	flags := flag.NewFlagSet("analyze", flag.ExitOnError)
+	ruleRe := flags.String("rule", "", "Regexp for rules to include, default: all")
+	fromStr := flags.String("from", "", "Include requests sent after time (RFC3339), default: all")
+	untilStr := flags.String("until", "", "Include requests sent before time (RFC3339), default: all")
+	statuses := flags.String("status", "", "Comma separated outcome classes to include, e.g. ok,http_5xx, default: all")
+	groupBy := flags.String("group_by", GroupByRule, "Grouping: rule, query, stage, status, cache (cold OR warm), tags (tag count) OR period")
+	output := flags.String("output", TextOutput, "Output format: text OR json")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s analyze [options] log.ndjson [log.ndjson ...]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return fmt.Errorf("No request logs to analyze")
+	}
+
+	var filter LogFilter
+	var err error
+	if *ruleRe != "" {
+		filter.Rule, err = regexp.Compile(*ruleRe)
+		if err != nil {
+			return err
+		}
+	}
+	filter.From, err = parseTime(*fromStr)
+	if err != nil {
+		return err
+	}
+	filter.Until, err = parseTime(*untilStr)
+	if err != nil {
+		return err
+	}
+	if *statuses != "" {
+		filter.Statuses = make(map[string]bool)
+		for _, s := range strings.Split(*statuses, ",") {
+			filter.Statuses[strings.TrimSpace(s)] = true
+		}
+	}
+
+	analysis, err := Analyze(flags.Args(), &filter, *groupBy)
+	if err != nil {
+		return err
+	}
+
+	switch *output {
+	case TextOutput:
+		analysis.Print(os.Stdout)
+		return nil
+	case JSONOutput:
+		return analysis.WriteJSON(os.Stdout)
+	}
+	return fmt.Errorf("Unknown output format '%s'", *output)
+}
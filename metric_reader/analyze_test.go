@@ -0,0 +1,67 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestAnalyze(t *testing.T) {
+	dir, err := ioutil.TempDir("", "analyze")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "log.ndjson")
+	requestLog, err := NewRequestLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Unix(1600000000, 0)
+	add := func(rule string, query string, period time.Duration, status string, elapsed time.Duration) {
+		var request requestData
+		request.Rule = rule
+		request.MetricName = query
+		request.Scheduled = start
+		request.From = start.Add(-period)
+		request.Until = start
+		request.Status = status
+		request.Failed = status != "ok"
+		request.Elapsed = elapsed
+		requestLog.Write(request)
+	}
+	add("a[1h]", "'dc=a'", time.Hour, "ok", 10*time.Millisecond)
+	add("a[1h]", "'dc=a','env=b'", time.Hour, "http_5xx", 20*time.Millisecond)
+	add("b[24h]", "'dc=a'", 24*time.Hour, "ok", 30*time.Millisecond)
+	requestLog.Close()
+
+	analysis, err := Analyze([]string{path}, &LogFilter{}, GroupByRule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if analysis.Total.Latency.Count() != 3 || analysis.Total.Failed != 1 {
+		t.Errorf("Not expected total: %d, failed %d", analysis.Total.Latency.Count(), analysis.Total.Failed)
+	}
+	if analysis.Groups["a[1h]"].Latency.Count() != 2 {
+		t.Errorf("Not expected groups: %v", analysis.Groups)
+	}
+
+	analysis, _ = Analyze([]string{path}, &LogFilter{Statuses: map[string]bool{"ok": true}}, GroupByTagCount)
+	if analysis.Total.Latency.Count() != 2 || analysis.Groups["001"].Latency.Count() != 2 {
+		t.Errorf("Not expected tag groups: %v", analysis.Groups)
+	}
+
+	analysis, _ = Analyze([]string{path}, &LogFilter{Rule: regexp.MustCompile("^b")}, GroupByPeriod)
+	if analysis.Total.Latency.Count() != 1 || analysis.Groups["24h0m0s"] == nil {
+		t.Errorf("Not expected period groups: %v", analysis.Groups)
+	}
+
+	if _, err := Analyze([]string{path}, &LogFilter{}, "bad"); err == nil {
+		t.Error("Error should not be nil for unknown grouping")
+	}
+}
@@ -0,0 +1,151 @@
+package backend
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Outcome classes of request
+const (
+	OK             = "ok"
+	TransportError = "transport_error"
+	Timeout        = "timeout"
+	BackendError   = "backend_error"
+	ParseError     = "parse_error"
+	EmptyResult    = "empty_result"
+	// Canceled is a request aborted on shutdown, it is neither success nor failure
+	Canceled = "canceled"
+)
+
+// HTTPStatusClass returns outcome class for unexpected HTTP status, e.g. http_5xx
+func HTTPStatusClass(statusCode int) string {
+	return fmt.Sprintf("http_%dxx", statusCode/100)
+}
+
+// IsSuccess returns true for outcome classes of successful requests
+func IsSuccess(class string) bool {
+	return class == OK || class == EmptyResult
+}
+
+// Query is a single query to backend after template substitution
+type Query struct {
+	Expr  string
+	From  time.Time
+	Until time.Time
+	Range bool
+	// MaxDataPoints limits points per series, default: backend specific
+	MaxDataPoints int
+	// Format is a response format, if backend supports several, default: json
+	Format string
+	// Step is a range query step, default: computed from MaxDataPoints
+	Step time.Duration
+}
+
+// Request is a prepared HTTP request to backend
+type Request struct {
+	Method string
+	URL    string
+	Header http.Header
+	Body   []byte
+}
+
+// Backend is a load test target: it discovers series, builds requests and validates responses
+type Backend interface {
+	// Name returns backend name as used in -source
+	Name() string
+	// RandomSeries returns random series expression to substitute into rule template
+	RandomSeries() (string, error)
+	// RandomTagValue returns random value of tag (label)
+	RandomTagValue(tag string) (string, error)
+	// TagMatcher returns expression matching tag value, in series expression syntax
+	TagMatcher(tag string, value string) string
+	// ParseSeries returns tags of series expression returned by RandomSeries or Discover
+	ParseSeries(series string) map[string]string
+	// Discover returns all series expressions, crawling with at most concurrency requests
+	Discover(concurrency int) ([]string, error)
+	// BuildRequest returns HTTP request for query
+	BuildRequest(query Query) Request
+	// Interval returns interval between data points of query response, like Grafana $__interval
+	Interval(query Query) time.Duration
+	// Validate checks response status and body of query and returns outcome class
+	// with error describing failure
+	Validate(query Query, statusCode int, body []byte) (string, error)
+}
+
+// Factory creates backend for base URL
+type Factory func(baseURL string) Backend
+
+var registry = make(map[string]Factory)
+
+// Register adds backend factory to registry, name is case insensitive
+func Register(name string, factory Factory) {
+	registry[strings.ToLower(name)] = factory
+}
+
+// New creates registered backend by name
+func New(name string, baseURL string) (Backend, error) {
+	factory, ok := registry[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("Unknown source '%s', should be one of: %s", name, strings.Join(Names(), ", "))
+	}
+	return factory(baseURL), nil
+}
+
+// Names returns sorted names of registered backends
+func Names() []string {
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Crawl calls f for every item using at most concurrency goroutines
+// and returns all results. Crawling stops on the first error
+func Crawl(items []string, concurrency int, f func(string) ([]string, error)) ([]string, error) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var firstErr error
+	ret := make([]string, 0)
+
+	itemsChan := make(chan string)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range itemsChan {
+				res, err := f(item)
+
+				mu.Lock()
+				if err != nil && firstErr == nil {
+					firstErr = err
+				}
+				ret = append(ret, res...)
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for _, item := range items {
+		mu.Lock()
+		failed := firstErr != nil
+		mu.Unlock()
+		if failed {
+			break
+		}
+		itemsChan <- item
+	}
+	close(itemsChan)
+	wg.Wait()
+
+	return ret, firstErr
+}
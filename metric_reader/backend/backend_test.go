@@ -0,0 +1,36 @@
+package backend
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+)
+
+func TestCrawl(t *testing.T) {
+	items := []string{"a", "b", "c", "d"}
+	ret, err := Crawl(items, 2, func(item string) ([]string, error) {
+		return []string{item + "1", item + "2"}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ret) != 8 {
+		t.Fatalf("Not expected results count: %d", len(ret))
+	}
+	sort.Strings(ret)
+	if ret[0] != "a1" || ret[7] != "d2" {
+		t.Errorf("Not expected results: %v", ret)
+	}
+}
+
+func TestCrawlError(t *testing.T) {
+	_, err := Crawl([]string{"a", "b", "c"}, 1, func(item string) ([]string, error) {
+		if item == "b" {
+			return nil, fmt.Errorf("failed %s", item)
+		}
+		return []string{item}, nil
+	})
+	if err == nil {
+		t.Error("Error should not be nil")
+	}
+}
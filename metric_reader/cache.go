@@ -0,0 +1,157 @@
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/ifireice/metric_reader/metric_reader/backend"
+)
+
+// Cache groups of requests: warm is an exact repeat of earlier request URL,
+// cold is the first request of URL or a repeat with cache busting
+const (
+	CacheCold = "cold"
+	CacheWarm = "warm"
+)
+
+// Cache busting modes
+const (
+	NoBust    = ""
+	TimeBust  = "time"
+	NonceBust = "nonce"
+)
+
+// maxBustShift is a max shift of time range in TimeBust mode
+const maxBustShift = time.Minute
+
+// ReplayCache keeps recent requests to repeat them, requests are identified by Key, or URL if it is empty.
+// At most Size requests are kept, the least recently used are evicted
+type ReplayCache struct {
+	// Repeat is a probability to repeat cached request instead of generating new one
+	Repeat float64
+	// Bust is a cache busting mode applied to every request
+	Bust string
+	Size int
+	lru  *list.List
+	// items and keys index lru elements by key and by position for random pick
+	items  map[string]*list.Element
+	keys   []string
+	random *rand.Rand
+}
+
+type cacheItem struct {
+	request requestData
+	key     string
+	index   int
+}
+
+// CacheKey returns identity of generated request which doesn't depend on clock: rule, query
+// and range relative to generation time, so the same random draws give the same cache state
+func CacheKey(request requestData, offset time.Duration, period time.Duration) string {
+	q := request.Query
+	return fmt.Sprintf("%s|%s|%s|%s|%t|%s|%s|%d", request.Rule, q.Expr, offset, period, q.Range, q.Step, q.Format, q.MaxDataPoints)
+}
+
+// ParseBust checks cache busting mode
+func ParseBust(mode string) (string, error) {
+	switch mode {
+	case NoBust, TimeBust, NonceBust:
+		return mode, nil
+	}
+	return "", fmt.Errorf("Unknown cache busting mode '%s'", mode)
+}
+
+// NewReplayCache returns empty cache of at most size requests
+func NewReplayCache(repeat float64, size int, bust string, r *rand.Rand) *ReplayCache {
+	return &ReplayCache{
+		Repeat: repeat,
+		Bust:   bust,
+		Size:   size,
+		lru:    list.New(),
+		items:  make(map[string]*list.Element),
+		random: r,
+	}
+}
+
+// Len returns number of cached requests
+func (c *ReplayCache) Len() int {
+	return c.lru.Len()
+}
+
+// Add stores request as the most recently used
+func (c *ReplayCache) Add(request requestData) {
+	if c.Size <= 0 {
+		return
+	}
+	key := request.Key
+	if key == "" {
+		key = request.URL
+	}
+	if e, ok := c.items[key]; ok {
+		e.Value.(*cacheItem).request = request
+		c.lru.MoveToFront(e)
+		return
+	}
+
+	c.items[key] = c.lru.PushFront(&cacheItem{request, key, len(c.keys)})
+	c.keys = append(c.keys, key)
+	if c.lru.Len() > c.Size {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *ReplayCache) remove(e *list.Element) {
+	item := c.lru.Remove(e).(*cacheItem)
+	delete(c.items, item.key)
+
+	last := len(c.keys) - 1
+	if item.index != last {
+		moved := c.keys[last]
+		c.keys[item.index] = moved
+		c.items[moved].Value.(*cacheItem).index = item.index
+	}
+	c.keys = c.keys[:last]
+}
+
+// Next returns random cached request with Repeat probability
+func (c *ReplayCache) Next() (requestData, bool) {
+	if c.lru.Len() == 0 || c.random.Float64() >= c.Repeat {
+		return requestData{}, false
+	}
+	e := c.items[c.keys[c.random.Intn(len(c.keys))]]
+	c.lru.MoveToFront(e)
+	return e.Value.(*cacheItem).request, true
+}
+
+// BustRequest returns request changed according to cache busting mode, so backend cache misses
+func BustRequest(b backend.Backend, request requestData, mode string, r *rand.Rand) requestData {
+	switch mode {
+	case TimeBust:
+		shift := time.Duration(r.Int63n(int64(maxBustShift/time.Second))+1) * time.Second
+		request = shiftRequest(b, request, -shift)
+	case NonceBust:
+		sep := "?"
+		if strings.Contains(request.URL, "?") {
+			sep = "&"
+		}
+		request.URL = fmt.Sprintf("%s%s_nonce=%d", request.URL, sep, r.Int63())
+	}
+	return request
+}
+
+// shiftRequest returns request with query range moved by shift
+func shiftRequest(b backend.Backend, request requestData, shift time.Duration) requestData {
+	request.Query.From = request.Query.From.Add(shift)
+	request.Query.Until = request.Query.Until.Add(shift)
+	request.From = request.Query.From
+	request.Until = request.Query.Until
+	built := b.BuildRequest(request.Query)
+	request.Method = built.Method
+	request.URL = built.URL
+	request.Header = built.Header
+	request.Body = built.Body
+	return request
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ifireice/metric_reader/metric_reader/backend"
+	"github.com/ifireice/metric_reader/metric_reader/carbon"
+)
+
+func TestReplayCacheLRU(t *testing.T) {
+	c := NewReplayCache(1, 3, NoBust, rand.New(rand.NewSource(1)))
+	for i := 0; i < 3; i++ {
+		c.Add(requestData{URL: fmt.Sprintf("u%d", i)})
+	}
+	// u0 is used again, so u1 is the least recently used
+	c.Add(requestData{URL: "u0", Rule: "again"})
+	c.Add(requestData{URL: "u3"})
+
+	if c.Len() != 3 {
+		t.Fatalf("Len: %d != 3", c.Len())
+	}
+	if _, ok := c.items["u1"]; ok {
+		t.Error("u1 should be evicted")
+	}
+	seen := make(map[string]string)
+	for i := 0; i < 200; i++ {
+		r, ok := c.Next()
+		if !ok {
+			t.Fatal("Request should be repeated with probability 1")
+		}
+		seen[r.URL] = r.Rule
+	}
+	if len(seen) != 3 || seen["u0"] != "again" {
+		t.Errorf("Not expected repeated requests: %v", seen)
+	}
+}
+
+func TestReplayCacheKey(t *testing.T) {
+	c := NewReplayCache(1, 3, NoBust, rand.New(rand.NewSource(1)))
+	request := requestData{URL: "u0", Rule: "r", Query: backend.Query{Expr: "a"}}
+	request.Key = CacheKey(request, time.Minute, time.Hour)
+	c.Add(request)
+
+	// the same request generated a second later has other URL
+	request.URL = "u1"
+	c.Add(request)
+	if c.Len() != 1 || c.items[request.Key].Value.(*cacheItem).request.URL != "u1" {
+		t.Errorf("Request should be updated by key: %d", c.Len())
+	}
+
+	request.Key = CacheKey(request, 2*time.Minute, time.Hour)
+	c.Add(request)
+	if c.Len() != 2 {
+		t.Errorf("Request of other range should be added: %d", c.Len())
+	}
+}
+
+func TestReplayCacheDisabled(t *testing.T) {
+	c := NewReplayCache(0, 10, NoBust, rand.New(rand.NewSource(1)))
+	c.Add(requestData{URL: "u"})
+	if _, ok := c.Next(); ok {
+		t.Error("Request should not be repeated with probability 0")
+	}
+
+	c = NewReplayCache(1, 0, NoBust, rand.New(rand.NewSource(1)))
+	c.Add(requestData{URL: "u"})
+	if _, ok := c.Next(); ok {
+		t.Error("Request should not be repeated with zero cache size")
+	}
+}
+
+func TestBustRequest(t *testing.T) {
+	b := carbon.New("http://localhost")
+	query := backend.Query{Expr: "a", From: time.Unix(1600000000, 0), Until: time.Unix(1600003600, 0)}
+	built := b.BuildRequest(query)
+	request := requestData{URL: built.URL, Query: query, From: query.From, Until: query.Until}
+
+	busted := BustRequest(b, request, TimeBust, rand.New(rand.NewSource(1)))
+	if busted.URL == request.URL || busted.Until.Sub(busted.From) != time.Hour || !busted.Until.Before(request.Until) {
+		t.Errorf("Not expected time busted request: %s %s-%s", busted.URL, busted.From, busted.Until)
+	}
+
+	busted = BustRequest(b, request, NonceBust, rand.New(rand.NewSource(1)))
+	if !strings.HasPrefix(busted.URL, request.URL+"&_nonce=") {
+		t.Errorf("Not expected nonce busted URL: %s", busted.URL)
+	}
+
+	if _, err := ParseBust("sometimes"); err == nil {
+		t.Error("Error should not be nil for unknown busting mode")
+	}
+}
@@ -0,0 +1,130 @@
+package carbon
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ifireice/metric_reader/metric_reader/backend"
+)
+
+// Name is a backend name for -source
+const Name = "Carbon"
+
+// JSONFormat is a default render format, other formats are only checked for status and non-empty body
+const JSONFormat = "json"
+
+func init() {
+	backend.Register(Name, New)
+}
+
+// Backend is a graphite render API backend (carbonapi, graphite-web)
+type Backend struct {
+	URL string
+}
+
+// New returns carbon backend for base URL
+func New(baseURL string) backend.Backend {
+	return &Backend{URL: baseURL}
+}
+
+// Name returns backend name
+func (b *Backend) Name() string {
+	return Name
+}
+
+// RandomSeries returns random seriesByTag expression
+func (b *Backend) RandomSeries() (string, error) {
+	return GetRandomTags(b.URL)
+}
+
+// RandomTagValue returns random value of tag
+func (b *Backend) RandomTagValue(tag string) (string, error) {
+	values, err := getTagValues(b.URL, tag)
+	if err != nil {
+		return "", err
+	}
+	if len(values) == 0 {
+		return "", fmt.Errorf("No values found for tag %s", tag)
+	}
+	return getRandom(values), nil
+}
+
+// TagMatcher returns seriesByTag argument: 'tag=value'
+func (b *Backend) TagMatcher(tag string, value string) string {
+	return fmt.Sprintf("'%s=%s'", tag, value)
+}
+
+// ParseSeries returns tags of tag set like 'dc=a','env=b'
+func (b *Backend) ParseSeries(series string) map[string]string {
+	tags := make(map[string]string)
+	for _, m := range SplitTerms(series) {
+		if i := strings.Index(m, "="); i > 0 {
+			tags[m[:i]] = m[i+1:]
+		}
+	}
+	return tags
+}
+
+// Discover returns all unique tag sets
+func (b *Backend) Discover(concurrency int) ([]string, error) {
+	return DiscoverMetrics(b.URL, concurrency)
+}
+
+// BuildRequest returns render request for query, step is not supported by render API.
+// maxDataPoints is sent only if set, otherwise series are not consolidated
+func (b *Backend) BuildRequest(query backend.Query) backend.Request {
+	format := query.Format
+	if format == "" {
+		format = JSONFormat
+	}
+	renderURL := GetFormatURL(b.URL, query.Expr, query.From, query.Until, format)
+	if query.MaxDataPoints > 0 {
+		renderURL = fmt.Sprintf("%s&maxDataPoints=%d", renderURL, query.MaxDataPoints)
+	}
+	return backend.Request{Method: http.MethodGet, URL: renderURL}
+}
+
+// defaultInterval is a common retention resolution, data points are not consolidated without maxDataPoints
+const defaultInterval = time.Minute
+
+// Interval returns interval of data points consolidated to MaxDataPoints, not less than 1s
+func (b *Backend) Interval(query backend.Query) time.Duration {
+	if query.MaxDataPoints <= 0 {
+		return defaultInterval
+	}
+	seconds := math.Ceil(query.Until.Sub(query.From).Seconds() / float64(query.MaxDataPoints))
+	if seconds < 1 {
+		seconds = 1
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// Validate checks render response is successful JSON list of series
+func (b *Backend) Validate(query backend.Query, statusCode int, body []byte) (string, error) {
+	if statusCode < 200 || statusCode > 299 {
+		return backend.HTTPStatusClass(statusCode), fmt.Errorf("HTTP status %d: %.100s", statusCode, body)
+	}
+	if len(body) == 0 {
+		return backend.ParseError, fmt.Errorf("Empty body")
+	}
+	if query.Format != "" && query.Format != JSONFormat {
+		return backend.OK, nil
+	}
+
+	var series []struct {
+		Target     string          `json:"target"`
+		Datapoints json.RawMessage `json:"datapoints"`
+	}
+	err := json.Unmarshal(body, &series)
+	if err != nil {
+		return backend.ParseError, err
+	}
+	if len(series) == 0 {
+		return backend.EmptyResult, nil
+	}
+	return backend.OK, nil
+}
@@ -0,0 +1,79 @@
+package carbon
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ifireice/metric_reader/metric_reader/backend"
+)
+
+func TestValidate(t *testing.T) {
+	b := New("http://localhost")
+	check := func(statusCode int, body string, expected string) {
+		class, _ := b.Validate(backend.Query{}, statusCode, []byte(body))
+		if class != expected {
+			t.Errorf("Validate(%d, '%s'): %s != %s", statusCode, body, class, expected)
+		}
+	}
+	check(200, `[{"target":"a","datapoints":[[1,1600000000]]}]`, backend.OK)
+	check(200, `[]`, backend.EmptyResult)
+	check(200, ``, backend.ParseError)
+	check(200, `<html>`, backend.ParseError)
+	check(500, `error`, "http_5xx")
+	check(404, `[]`, "http_4xx")
+
+	if class, _ := b.Validate(backend.Query{Format: "csv"}, 200, []byte("a,1,2")); class != backend.OK {
+		t.Errorf("Validate of csv: %s != %s", class, backend.OK)
+	}
+}
+
+func TestParseSeries(t *testing.T) {
+	tags := New("").ParseSeries("'dc=a','env=b=c'")
+	if len(tags) != 2 || tags["dc"] != "a" || tags["env"] != "b=c" {
+		t.Errorf("Not expected tags: %v", tags)
+	}
+
+	tags = New("").ParseSeries("'dc=a,b','env=c'")
+	if len(tags) != 2 || tags["dc"] != "a,b" || tags["env"] != "c" {
+		t.Errorf("Not expected tags of value with comma: %v", tags)
+	}
+}
+
+func TestInterval(t *testing.T) {
+	until := time.Unix(1600000000, 0)
+	check := func(period time.Duration, maxDataPoints int, expected time.Duration) {
+		query := backend.Query{From: until.Add(-period), Until: until, MaxDataPoints: maxDataPoints}
+		if interval := New("").Interval(query); interval != expected {
+			t.Errorf("Interval(%s, %d): %s != %s", period, maxDataPoints, interval, expected)
+		}
+	}
+	check(time.Minute, 1000, time.Second)
+	check(time.Hour, 1000, 4*time.Second)
+	check(24*time.Hour, 1000, 87*time.Second)
+	check(24*time.Hour, 0, time.Minute)
+}
+
+func TestBuildRequestEscape(t *testing.T) {
+	target := "alias(seriesByTag('name=a+b'),'50% & #1')"
+	query := backend.Query{Expr: target, From: time.Unix(1600000000, 0), Until: time.Unix(1600003600, 0)}
+	request := New("http://localhost").BuildRequest(query)
+
+	u, err := url.Parse(request.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := u.Query()
+	if params.Get("target") != target || params.Get("from") != "1600000000" || params.Get("format") != JSONFormat {
+		t.Errorf("Not expected render params of %s: %v", request.URL, params)
+	}
+	// series are not consolidated unless asked
+	if _, ok := params["maxDataPoints"]; ok {
+		t.Errorf("maxDataPoints should not be sent by default: %s", request.URL)
+	}
+	query.MaxDataPoints = 500
+	if request := New("http://localhost").BuildRequest(query); !strings.HasSuffix(request.URL, "&maxDataPoints=500") {
+		t.Errorf("Not expected URL with maxDataPoints: %s", request.URL)
+	}
+}
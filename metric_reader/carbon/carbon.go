@@ -7,12 +7,23 @@ import (
 	"io/ioutil"
 	"math/rand"
 	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
 	"time"
+
+	"github.com/ifireice/metric_reader/metric_reader/backend"
 )
 
 // GetURL generates full URL for prometheus API
-func GetURL(url string, metricName string, from time.Time, until time.Time) string {
-	return fmt.Sprintf("%s/render/?target=%s&from=%v&until=%v&format=json", url, metricName, from.Unix(), until.Unix())
+func GetURL(baseURL string, metricName string, from time.Time, until time.Time) string {
+	return GetFormatURL(baseURL, metricName, from, until, JSONFormat)
+}
+
+// GetFormatURL generates render URL with response format, e.g. json, csv OR protobuf
+func GetFormatURL(baseURL string, metricName string, from time.Time, until time.Time, format string) string {
+	return fmt.Sprintf("%s/render/?target=%s&from=%v&until=%v&format=%s", baseURL, url.QueryEscape(metricName), from.Unix(), until.Unix(), format)
 }
 
 func getAllTagNames(carbonURL string) ([]string, error) {
@@ -125,7 +136,64 @@ func joinTags(tags []string) string {
 	return ret.String()
 }
 
-func getAllMetricsRecurse(baseURL string, currentTags []string) ([]string, error) {
+// SplitTerms returns unquoted 'k=v' terms of series, commas inside quotes are a part of the term
+func SplitTerms(series string) []string {
+	terms := make([]string, 0)
+	term := bytes.NewBuffer([]byte(""))
+	var quote rune
+	for _, c := range series {
+		switch {
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+			term.WriteRune(c)
+		case c == '\'' || c == '"':
+			quote = c
+		case c == ',':
+			if t := strings.TrimSpace(term.String()); t != "" {
+				terms = append(terms, t)
+			}
+			term.Reset()
+		default:
+			term.WriteRune(c)
+		}
+	}
+	if t := strings.TrimSpace(term.String()); t != "" {
+		terms = append(terms, t)
+	}
+	return terms
+}
+
+// tagSets is a set of tag sets visited by crawlers, the same set is reachable
+// by adding its tags in any order
+type tagSets struct {
+	mu   sync.Mutex
+	seen map[string]bool
+}
+
+func newTagSets() *tagSets {
+	return &tagSets{seen: make(map[string]bool)}
+}
+
+// visit returns false if tag set is already visited
+func (s *tagSets) visit(tags []string) bool {
+	sorted := append([]string(nil), tags...)
+	sort.Strings(sorted)
+	key := joinTags(sorted)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.seen[key] {
+		return false
+	}
+	s.seen[key] = true
+	return true
+}
+
+func getAllMetricsRecurse(baseURL string, currentTags []string, visited *tagSets) ([]string, error) {
+	if !visited.visit(currentTags) {
+		return make([]string, 0), nil
+	}
 	nextTags, err := genAutoCompleteTags(baseURL, currentTags)
 	if err != nil {
 		return make([]string, 0), err
@@ -148,8 +216,10 @@ func getAllMetricsRecurse(baseURL string, currentTags []string) ([]string, error
 		}
 
 		for _, tv := range nextTagsValues {
-			nextCurrentTags := append(currentTags, fmt.Sprintf("%s=%s", tag, tv))
-			nextMetrics, err := getAllMetricsRecurse(baseURL, nextCurrentTags)
+			nextCurrentTags := make([]string, len(currentTags), len(currentTags)+1)
+			copy(nextCurrentTags, currentTags)
+			nextCurrentTags = append(nextCurrentTags, fmt.Sprintf("%s=%s", tag, tv))
+			nextMetrics, err := getAllMetricsRecurse(baseURL, nextCurrentTags, visited)
 			if err != nil {
 				return ret, err
 			}
@@ -159,7 +229,6 @@ func getAllMetricsRecurse(baseURL string, currentTags []string) ([]string, error
 			}
 		}
 	}
-	fmt.Println(ret)
 	return ret, nil
 }
 
@@ -171,6 +240,7 @@ func GetAllMetrics(url string) ([]string, error) {
 		return metrics, err
 	}
 
+	visited := newTagSets()
 	for _, tagName := range tagNames {
 		tagValues, err := getTagValues(url, tagName)
 		if err != nil {
@@ -179,7 +249,7 @@ func GetAllMetrics(url string) ([]string, error) {
 
 		for _, tagValue := range tagValues {
 			currentTags := []string{fmt.Sprintf("%s=%s", tagName, tagValue)}
-			nextMetrics, err := getAllMetricsRecurse(url, currentTags)
+			nextMetrics, err := getAllMetricsRecurse(url, currentTags, visited)
 			if err != nil {
 				return metrics, err
 			}
@@ -216,7 +286,54 @@ func getRandom(a []string) string {
 	return a[n]
 }
 
-// GetRandomTags returns ...
+// nameTag is a tag of metric name, graphite requires it in every tagged series
+const nameTag = "name"
+
+// DiscoverMetrics returns all unique targets with tags.
+// Crawling starts from every value of name tag, which every tagged series has, OR from every
+// tag=value pair if there is no name tag, with at most concurrency requests at once.
+// Already visited tag sets are not crawled again
+func DiscoverMetrics(carbonURL string, concurrency int) ([]string, error) {
+	tagsValues, err := GetAllTagsValues(carbonURL)
+	if err != nil {
+		return make([]string, 0), err
+	}
+
+	pairs := make([]string, 0)
+	for tagName, tagValues := range tagsValues {
+		if _, ok := tagsValues[nameTag]; ok && tagName != nameTag {
+			continue
+		}
+		for _, tagValue := range tagValues {
+			pairs = append(pairs, fmt.Sprintf("%s=%s", tagName, tagValue))
+		}
+	}
+
+	visited := newTagSets()
+	found, err := backend.Crawl(pairs, concurrency, func(pair string) ([]string, error) {
+		return getAllMetricsRecurse(carbonURL, []string{pair}, visited)
+	})
+	if err != nil {
+		return found, err
+	}
+
+	// terms are ordered as crawled, keep one order
+	unique := make(map[string]bool)
+	metrics := make([]string, 0)
+	for _, m := range found {
+		tags := SplitTerms(m)
+		sort.Strings(tags)
+		key := joinTags(tags)
+		if !unique[key] {
+			unique[key] = true
+			metrics = append(metrics, key)
+		}
+	}
+	sort.Strings(metrics)
+	return metrics, nil
+}
+
+// GetRandomTags returns random tag set walking autoComplete API
 func GetRandomTags(baseURL string) (string, error) {
 	allTags, err := getAllTagNames(baseURL)
 	if err != nil {
@@ -228,7 +345,6 @@ func GetRandomTags(baseURL string) (string, error) {
 	tags := make([]string, 0)
 
 	for {
-		fmt.Println(tags)
 		nextTagsValues, err := genAutoCompleteValues(baseURL, tags, tag)
 		if err != nil {
 			return "", err
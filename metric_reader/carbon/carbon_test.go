@@ -0,0 +1,105 @@
+package carbon
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// tagsServer serves tags API of series
+func tagsServer(series []map[string]string, requests *int64) *httptest.Server {
+	// matching returns series having all tags of expr values
+	matching := func(exprs []string) []map[string]string {
+		ret := make([]map[string]string, 0)
+		for _, s := range series {
+			ok := true
+			for _, expr := range exprs {
+				kv := strings.SplitN(expr, "=", 2)
+				if s[kv[0]] != kv[1] {
+					ok = false
+				}
+			}
+			if ok {
+				ret = append(ret, s)
+			}
+		}
+		return ret
+	}
+	values := func(series []map[string]string, tag string) []string {
+		set := make(map[string]bool)
+		for _, s := range series {
+			if v, ok := s[tag]; ok {
+				set[v] = true
+			}
+		}
+		ret := make([]string, 0)
+		for v := range set {
+			ret = append(ret, v)
+		}
+		sort.Strings(ret)
+		return ret
+	}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(requests, 1)
+		exprs := r.URL.Query()["expr"]
+		var ret []string
+		switch r.URL.Path {
+		case "/tags":
+			ret = []string{"dc", "env", "name"}
+		case "/tags/autoComplete/values":
+			ret = values(matching(exprs), r.URL.Query().Get("tag"))
+		case "/tags/autoComplete/tags":
+			used := make(map[string]bool)
+			for _, expr := range exprs {
+				used[strings.SplitN(expr, "=", 2)[0]] = true
+			}
+			set := make(map[string]bool)
+			for _, s := range matching(exprs) {
+				for k := range s {
+					if !used[k] {
+						set[k] = true
+					}
+				}
+			}
+			ret = make([]string, 0)
+			for k := range set {
+				ret = append(ret, k)
+			}
+			sort.Strings(ret)
+		}
+		json.NewEncoder(w).Encode(ret)
+	}))
+}
+
+func TestDiscoverMetrics(t *testing.T) {
+	var requests int64
+	server := tagsServer([]map[string]string{
+		{"name": "a", "dc": "x,y", "env": "prod"},
+		{"name": "a", "dc": "z", "env": "prod"},
+		{"name": "b", "dc": "z", "env": "test"},
+	}, &requests)
+	defer server.Close()
+
+	metrics, err := DiscoverMetrics(server.URL, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"'dc=x,y','env=prod','name=a'",
+		"'dc=z','env=prod','name=a'",
+		"'dc=z','env=test','name=b'",
+	}
+	if !reflect.DeepEqual(metrics, expected) {
+		t.Errorf("Not expected metrics: %v", metrics)
+	}
+	// every tag set is crawled once starting from name values, 72 requests without pruning
+	if requests > 30 {
+		t.Errorf("Too many requests: %d", requests)
+	}
+}
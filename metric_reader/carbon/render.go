@@ -0,0 +1,88 @@
+package carbon
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// offsetRe matches relative time offset, e.g. -1h, -30min OR +1d
+var offsetRe = regexp.MustCompile(`^([+-])(\d+)([a-z]+)$`)
+
+// offsetUnits are graphite offset units, months and years are 30 and 365 days as in graphite-web
+var offsetUnits = map[string]time.Duration{
+	"s":       time.Second,
+	"sec":     time.Second,
+	"secs":    time.Second,
+	"second":  time.Second,
+	"seconds": time.Second,
+	"min":     time.Minute,
+	"mins":    time.Minute,
+	"minute":  time.Minute,
+	"minutes": time.Minute,
+	"h":       time.Hour,
+	"hour":    time.Hour,
+	"hours":   time.Hour,
+	"d":       24 * time.Hour,
+	"day":     24 * time.Hour,
+	"days":    24 * time.Hour,
+	"w":       7 * 24 * time.Hour,
+	"week":    7 * 24 * time.Hour,
+	"weeks":   7 * 24 * time.Hour,
+	"mon":     30 * 24 * time.Hour,
+	"month":   30 * 24 * time.Hour,
+	"months":  30 * 24 * time.Hour,
+	"y":       365 * 24 * time.Hour,
+	"year":    365 * 24 * time.Hour,
+	"years":   365 * 24 * time.Hour,
+}
+
+// ParseTime parses render from/until value at time now: 'now', offset like '-1h' OR 'now-1h',
+// unix timestamp, 'HH:MM_YYYYMMDD' OR 'YYYYMMDD' in loc
+func ParseTime(value string, now time.Time, loc *time.Location) (time.Time, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if value == "now" {
+		return now, nil
+	}
+	if strings.HasPrefix(value, "now") {
+		value = strings.TrimPrefix(value, "now")
+	}
+
+	if m := offsetRe.FindStringSubmatch(value); m != nil {
+		unit, ok := offsetUnits[m[3]]
+		if !ok {
+			return now, fmt.Errorf("Unknown time unit in '%s'", value)
+		}
+		n, err := strconv.ParseInt(m[2], 10, 64)
+		if err != nil {
+			return now, err
+		}
+		offset := time.Duration(n) * unit
+		if m[1] == "-" {
+			offset = -offset
+		}
+		return now.Add(offset), nil
+	}
+
+	// 8 digits are YYYYMMDD as in graphite-web, other numbers are unix timestamps
+	if ts, err := strconv.ParseInt(value, 10, 64); err == nil && len(value) != 8 {
+		return time.Unix(ts, 0), nil
+	}
+	for _, layout := range []string{"15:04_20060102", "20060102"} {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t, nil
+		}
+	}
+	return now, fmt.Errorf("Cant parse time '%s'", value)
+}
+
+// RelativeTime returns offset of t from now in seconds, e.g. -3600s, OR 'now' if t is not before now
+func RelativeTime(t time.Time, now time.Time) string {
+	offset := now.Sub(t) / time.Second
+	if offset <= 0 {
+		return "now"
+	}
+	return fmt.Sprintf("-%ds", offset)
+}
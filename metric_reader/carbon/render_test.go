@@ -0,0 +1,42 @@
+package carbon
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTime(t *testing.T) {
+	now := time.Date(2020, 9, 1, 12, 0, 0, 0, time.UTC)
+	for value, expected := range map[string]time.Time{
+		"now":            now,
+		"-1h":            now.Add(-time.Hour),
+		"-30min":         now.Add(-30 * time.Minute),
+		"now-7d":         now.Add(-7 * 24 * time.Hour),
+		"+10s":           now.Add(10 * time.Second),
+		"1598954400":     time.Unix(1598954400, 0),
+		"10:30_20200901": time.Date(2020, 9, 1, 10, 30, 0, 0, time.UTC),
+		"20200831":       time.Date(2020, 8, 31, 0, 0, 0, 0, time.UTC),
+	} {
+		parsed, err := ParseTime(value, now, time.UTC)
+		if err != nil {
+			t.Errorf("ParseTime('%s'): %s", value, err)
+			continue
+		}
+		if !parsed.Equal(expected) {
+			t.Errorf("ParseTime('%s'): %s != %s", value, parsed, expected)
+		}
+	}
+
+	for _, bad := range []string{"-1x", "yesterday", "1h"} {
+		if _, err := ParseTime(bad, now, time.UTC); err == nil {
+			t.Errorf("Error should not be nil for '%s'", bad)
+		}
+	}
+
+	if r := RelativeTime(now.Add(-time.Hour), now); r != "-3600s" {
+		t.Errorf("Not expected relative time: %s", r)
+	}
+	if r := RelativeTime(now.Add(time.Minute), now); r != "now" {
+		t.Errorf("Not expected relative time: %s", r)
+	}
+}
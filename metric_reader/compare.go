@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// ErrRegression is returned by compare when regression threshold is exceeded
+var ErrRegression = errors.New("Regression detected")
+
+// CompareOptions are regression thresholds
+type CompareOptions struct {
+	// Quantile of latency to compare, e.g. 0.99
+	Quantile float64
+	// LatencyThreshold is a max allowed latency increase, percent
+	LatencyThreshold float64
+	// ErrorThreshold is a max allowed error rate increase, percentage points
+	ErrorThreshold float64
+	// ThroughputThreshold is a max allowed throughput decrease, percent.
+	// Throughput is set by load model rather than sampled, so its delta is not tested for significance
+	ThroughputThreshold float64
+	// Alpha is a significance level for latency and error rate tests
+	Alpha float64
+}
+
+// Comparison is a comparison of base and new stats of the same group
+type Comparison struct {
+	Name       string
+	Base       *Stats
+	New        *Stats
+	LatencyP   float64 // Mann-Whitney p-value
+	ErrorP     float64 // two-proportion z-test p-value
+	Regression []string
+}
+
+func errorRate(s *Stats) float64 {
+	if s.Latency.Count() == 0 {
+		return 0
+	}
+	return float64(s.Failed) / float64(s.Latency.Count())
+}
+
+func deltaPercent(base float64, cur float64) float64 {
+	if base == 0 {
+		return 0
+	}
+	return (cur - base) / base * 100
+}
+
+// proportionTest compares error rates with two-proportion z-test, returns two-sided p-value
+func proportionTest(base *Stats, cur *Stats) float64 {
+	n1 := float64(base.Latency.Count())
+	n2 := float64(cur.Latency.Count())
+	if n1 == 0 || n2 == 0 {
+		return 1
+	}
+	pooled := (float64(base.Failed) + float64(cur.Failed)) / (n1 + n2)
+	se := math.Sqrt(pooled * (1 - pooled) * (1/n1 + 1/n2))
+	if se == 0 {
+		return 1
+	}
+	z := (errorRate(cur) - errorRate(base)) / se
+	return 2 * normalSF(math.Abs(z))
+}
+
+// Compare compares stats of the group and checks regression thresholds
+func Compare(name string, base *Stats, cur *Stats, opts CompareOptions) Comparison {
+	c := Comparison{Name: name, Base: base, New: cur}
+	z, p := MannWhitney(base.Latency, cur.Latency)
+	c.LatencyP = p
+	c.ErrorP = proportionTest(base, cur)
+
+	baseLatency := float64(base.Latency.Quantile(opts.Quantile))
+	curLatency := float64(cur.Latency.Quantile(opts.Quantile))
+	if z > 0 && p < opts.Alpha && deltaPercent(baseLatency, curLatency) > opts.LatencyThreshold {
+		c.Regression = append(c.Regression, fmt.Sprintf("p%g", opts.Quantile*100))
+	}
+
+	errorDelta := (errorRate(cur) - errorRate(base)) * 100
+	if errorDelta > opts.ErrorThreshold && c.ErrorP < opts.Alpha {
+		c.Regression = append(c.Regression, "errors")
+	}
+
+	// no significance test, see ThroughputThreshold
+	if deltaPercent(base.Throughput(), cur.Throughput()) < -opts.ThroughputThreshold {
+		c.Regression = append(c.Regression, "rps")
+	}
+	return c
+}
+
+// CompareAnalyses compares total and every group present in both analyses
+func CompareAnalyses(base *Analysis, cur *Analysis, opts CompareOptions) []Comparison {
+	ret := []Comparison{Compare("total", base.Total, cur.Total, opts)}
+	for _, k := range sortedKeys(base.Groups) {
+		if curStats, ok := cur.Groups[k]; ok {
+			ret = append(ret, Compare(k, base.Groups[k], curStats, opts))
+		}
+	}
+	return ret
+}
+
+// PrintComparisons writes human readable comparison table
+func PrintComparisons(w io.Writer, comparisons []Comparison, opts CompareOptions) {
+	q := fmt.Sprintf("p%g", opts.Quantile*100)
+	fmt.Fprintf(w, "%10s %10s %8s %10s %10s %8s %8s %8s %10s %10s %8s %9s %9s  %-12s %s\n",
+		"base p50", "new p50", "delta", "base "+q, "new "+q, "delta", "base err", "new err",
+		"base rps", "new rps", "delta", "lat p", "err p", "regression", "name")
+
+	for _, c := range comparisons {
+		bp50, np50 := c.Base.Latency.Quantile(0.5), c.New.Latency.Quantile(0.5)
+		bq, nq := c.Base.Latency.Quantile(opts.Quantile), c.New.Latency.Quantile(opts.Quantile)
+		regression := "-"
+		if len(c.Regression) > 0 {
+			regression = fmt.Sprint(c.Regression)
+		}
+		fmt.Fprintf(w, "%10s %10s %+7.1f%% %10s %10s %+7.1f%% %7.2f%% %7.2f%% %10.2f %10.2f %+7.1f%% %9.4f %9.4f  %-12s %s\n",
+			fmtDuration(bp50), fmtDuration(np50), deltaPercent(float64(bp50), float64(np50)),
+			fmtDuration(bq), fmtDuration(nq), deltaPercent(float64(bq), float64(nq)),
+			errorRate(c.Base)*100, errorRate(c.New)*100,
+			c.Base.Throughput(), c.New.Throughput(), deltaPercent(c.Base.Throughput(), c.New.Throughput()),
+			c.LatencyP, c.ErrorP, regression, c.Name)
+	}
+}
+
+// runCompare is 'compare' subcommand: compares two runs by request logs
+func runCompare(args []string) error {
+	flags := flag.NewFlagSet("compare", flag.ExitOnError)
+	var opts CompareOptions
+	flags.Float64Var(&opts.Quantile, "quantile", 0.99, "Latency quantile to check")
+	flags.Float64Var(&opts.LatencyThreshold, "latency_threshold", 10, "Max allowed latency quantile increase, percent")
+	flags.Float64Var(&opts.ErrorThreshold, "error_threshold", 1, "Max allowed error rate increase, percentage points")
+	flags.Float64Var(&opts.ThroughputThreshold, "rps_threshold", 10, "Max allowed throughput decrease, percent, not tested for significance")
+	flags.Float64Var(&opts.Alpha, "alpha", 0.05, "Significance level")
+	groupBy := flags.String("group_by", GroupByRule, "Grouping: rule, query, stage, status, cache (cold OR warm), tags (tag count) OR period")
+	totalOnly := flags.Bool("total_only", false, "Check thresholds for total only, groups are printed for information")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s compare [options] base.ndjson new.ndjson\n", os.Args[0])
+		fmt.Fprintln(flags.Output(), "Runs are compared by request logs written with -log, summaries of -output have no per request latency")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return fmt.Errorf("Two request logs are expected")
+	}
+
+	base, err := Analyze(flags.Args()[:1], &LogFilter{}, *groupBy)
+	if err != nil {
+		return err
+	}
+	cur, err := Analyze(flags.Args()[1:], &LogFilter{}, *groupBy)
+	if err != nil {
+		return err
+	}
+
+	comparisons := CompareAnalyses(base, cur, opts)
+	PrintComparisons(os.Stdout, comparisons, opts)
+
+	for i, c := range comparisons {
+		if len(c.Regression) > 0 && (i == 0 || !*totalOnly) {
+			return ErrRegression
+		}
+	}
+	fmt.Println("No regressions")
+	return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompare(t *testing.T) {
+	base := NewStats()
+	same := NewStats()
+	slow := NewStats()
+	start := time.Unix(1600000000, 0)
+	for i := 0; i < 500; i++ {
+		var request requestData
+		request.Scheduled = start.Add(time.Duration(i) * 10 * time.Millisecond)
+		request.Elapsed = time.Duration(100+i%50) * time.Millisecond
+		base.Add(request)
+		same.Add(request)
+		request.Elapsed += 50 * time.Millisecond
+		request.Failed = i%10 == 0
+		slow.Add(request)
+	}
+
+	opts := CompareOptions{Quantile: 0.99, LatencyThreshold: 10, ErrorThreshold: 1, ThroughputThreshold: 10, Alpha: 0.05}
+	if c := Compare("same", base, same, opts); len(c.Regression) != 0 {
+		t.Errorf("Not expected regression: %v", c.Regression)
+	}
+	c := Compare("slow", base, slow, opts)
+	if len(c.Regression) != 2 || c.Regression[0] != "p99" || c.Regression[1] != "errors" {
+		t.Errorf("Not expected regression: %v", c.Regression)
+	}
+}
+
+func TestCompareSummaryFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, format := range []string{JSONOutput, CSVOutput} {
+		path := filepath.Join(dir, "summary."+format)
+		if err := WriteSummary(NewSummary(10), options{}, nil, format, path); err != nil {
+			t.Fatal(err)
+		}
+		err := runCompare([]string{path, path})
+		if err == nil || !strings.Contains(err.Error(), "-log") {
+			t.Errorf("Not expected error of %s summary: %v", format, err)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/ifireice/metric_reader/metric_reader/backend"
+)
+
+// SeriesSource picks series and tag values to substitute into rule templates
+type SeriesSource interface {
+	RandomSeries() (string, error)
+	RandomTagValue(tag string) (string, error)
+}
+
+// Corpus is a snapshot of discovered series, saved once and loaded by later runs
+type Corpus struct {
+	Source  string    `json:"source"`
+	URL     string    `json:"url"`
+	Created time.Time `json:"created"`
+	Series  []string  `json:"series"`
+	// tags are tag values of series, built by Index
+	tags map[string][]string
+	// pick returns index of the next series, uniform if not set
+	pick func() int
+	// tagPicks return index of the next value of tag, uniform if not set
+	tagPicks map[string]func() int
+	// random is a source of picks set by SetDist, global if not set
+	random *rand.Rand
+}
+
+// DiscoverCorpus crawls all backend series with at most concurrency requests at once
+func DiscoverCorpus(b backend.Backend, url string, concurrency int) (*Corpus, error) {
+	series, err := b.Discover(concurrency)
+	if err != nil {
+		return nil, err
+	}
+	return &Corpus{Source: b.Name(), URL: url, Created: time.Now(), Series: series}, nil
+}
+
+// ReadCorpus reads corpus from JSON file
+func ReadCorpus(filepath string) (*Corpus, error) {
+	data, err := ioutil.ReadFile(filepath)
+	if err != nil {
+		return nil, err
+	}
+
+	var corpus Corpus
+	err = json.Unmarshal(data, &corpus)
+	if err != nil {
+		return nil, err
+	}
+	if len(corpus.Series) == 0 {
+		return nil, fmt.Errorf("Corpus '%s' has no series", filepath)
+	}
+	return &corpus, nil
+}
+
+// Write saves corpus to JSON file
+func (c *Corpus) Write(filepath string) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath, data, 0644)
+}
+
+// SetDist sets distribution of RandomSeries and RandomTagValue picks,
+// values of every tag are ranked separately. Corpus should be indexed before
+func (c *Corpus) SetDist(dist *SeriesDist, r *rand.Rand) {
+	c.pick = dist.NewPicker(len(c.Series), r)
+	c.random = r
+
+	// sorted, so pickers are the same for the same seed
+	tags := make([]string, 0, len(c.tags))
+	for tag := range c.tags {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	c.tagPicks = make(map[string]func() int, len(tags))
+	for _, tag := range tags {
+		c.tagPicks[tag] = dist.NewPicker(len(c.tags[tag]), r)
+	}
+}
+
+func (c *Corpus) intn(n int) int {
+	if c.random != nil {
+		return c.random.Intn(n)
+	}
+	return rand.Intn(n)
+}
+
+// RandomSeries returns random series from corpus
+func (c *Corpus) RandomSeries() (string, error) {
+	if c.pick != nil {
+		return c.Series[c.pick()], nil
+	}
+	return c.Series[c.intn(len(c.Series))], nil
+}
+
+// Index collects tag values of corpus series parsed by backend
+func (c *Corpus) Index(b backend.Backend) {
+	seen := make(map[string]map[string]bool)
+	c.tags = make(map[string][]string)
+	for _, series := range c.Series {
+		for tag, value := range b.ParseSeries(series) {
+			if seen[tag] == nil {
+				seen[tag] = make(map[string]bool)
+			}
+			if !seen[tag][value] {
+				seen[tag][value] = true
+				c.tags[tag] = append(c.tags[tag], value)
+			}
+		}
+	}
+}
+
+// RandomTagValue returns random value of tag found in corpus series
+func (c *Corpus) RandomTagValue(tag string) (string, error) {
+	values := c.tags[tag]
+	if len(values) == 0 {
+		return "", fmt.Errorf("No values found for tag %s in corpus", tag)
+	}
+	if pick, ok := c.tagPicks[tag]; ok {
+		return values[pick()], nil
+	}
+	return values[c.intn(len(values))], nil
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// version is set on build: -ldflags "-X main.version=..."
+var version = "dev"
+
+// Summary output formats
+const (
+	TextOutput = "text"
+	JSONOutput = "json"
+	CSVOutput  = "csv"
+)
+
+// StatsReport is a machine readable Stats, durations are in milliseconds
+type StatsReport struct {
+	Count    uint64  `json:"count"`
+	Failed   uint64  `json:"failed"`
+	Late     uint64  `json:"late"`
+	Dropped  uint64  `json:"dropped"`
+	Canceled uint64  `json:"canceled"`
+	Bytes    uint64  `json:"bytes"`
+	MinMs    float64 `json:"min_ms"`
+	P50Ms    float64 `json:"p50_ms"`
+	P90Ms    float64 `json:"p90_ms"`
+	P95Ms    float64 `json:"p95_ms"`
+	P99Ms    float64 `json:"p99_ms"`
+	P999Ms   float64 `json:"p99_9_ms"`
+	MaxMs    float64 `json:"max_ms"`
+	MeanMs   float64 `json:"mean_ms"`
+	StdDevMs float64 `json:"stddev_ms"`
+	RPS      float64 `json:"rps"`
+}
+
+func toMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// NewStatsReport returns report for stats
+func NewStatsReport(s *Stats) StatsReport {
+	h := s.Latency
+	return StatsReport{
+		Count:    h.Count(),
+		Failed:   s.Failed,
+		Late:     s.Late,
+		Dropped:  s.Dropped,
+		Canceled: s.Canceled,
+		Bytes:    s.Bytes,
+		MinMs:    toMs(h.Min()),
+		P50Ms:    toMs(h.Quantile(0.5)),
+		P90Ms:    toMs(h.Quantile(0.9)),
+		P95Ms:    toMs(h.Quantile(0.95)),
+		P99Ms:    toMs(h.Quantile(0.99)),
+		P999Ms:   toMs(h.Quantile(0.999)),
+		MaxMs:    toMs(h.Max()),
+		MeanMs:   toMs(h.Mean()),
+		StdDevMs: toMs(h.StdDev()),
+		RPS:      s.Throughput(),
+	}
+}
+
+func statsReports(m map[string]*Stats) map[string]StatsReport {
+	ret := make(map[string]StatsReport, len(m))
+	for k, v := range m {
+		ret[k] = NewStatsReport(v)
+	}
+	return ret
+}
+
+// Report is a summary with run metadata
+type Report struct {
+	Version string                 `json:"version"`
+	Start   time.Time              `json:"start"`
+	End     time.Time              `json:"end"`
+	Options options                `json:"options"`
+	Rules   []string               `json:"rules"`
+	Warmup  uint64                 `json:"warmup"`
+	Total   StatsReport            `json:"total"`
+	Stages  map[string]StatsReport `json:"stages"`
+	Classes map[string]StatsReport `json:"classes"`
+	Cache   map[string]StatsReport `json:"cache"`
+	Replay  map[string]StatsReport `json:"replay"`
+	Phases  map[string]StatsReport `json:"phases"`
+	ByRule  map[string]StatsReport `json:"by_rule"`
+	ByQuery map[string]StatsReport `json:"by_query"`
+}
+
+// NewReport returns report for summary of the run
+func NewReport(summary *Summary, opts options, rules []Rule) *Report {
+	ruleStrs := make([]string, 0, len(rules))
+	for _, r := range rules {
+		ruleStrs = append(ruleStrs, r.Label())
+	}
+
+	phases := make(map[string]StatsReport, len(summary.Phases))
+	for k, h := range summary.Phases {
+		phases[k] = NewStatsReport(&Stats{Latency: h})
+	}
+
+	return &Report{
+		Version: version,
+		Start:   summary.Start,
+		End:     summary.End,
+		Options: opts,
+		Rules:   ruleStrs,
+		Warmup:  summary.Warmup,
+		Total:   NewStatsReport(summary.Total),
+		Stages:  statsReports(summary.Stages),
+		Classes: statsReports(summary.Classes),
+		Cache:   statsReports(summary.Cache),
+		Replay:  statsReports(summary.Replay),
+		Phases:  phases,
+		ByRule:  statsReports(summary.Rules),
+		ByQuery: statsReports(summary.Queries),
+	}
+}
+
+// WriteJSON writes report as JSON document
+func (r *Report) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+// WriteCSV writes report as CSV table, one row per group.
+// Run metadata is written as '#' comment lines before the table
+func (r *Report) WriteCSV(w io.Writer) error {
+	fmt.Fprintf(w, "# version: %s\n", r.Version)
+	fmt.Fprintf(w, "# start: %s\n", r.Start.Format(time.RFC3339))
+	fmt.Fprintf(w, "# end: %s\n", r.End.Format(time.RFC3339))
+	fmt.Fprintf(w, "# source: %s\n", r.Options.Source)
+	fmt.Fprintf(w, "# url: %s\n", r.Options.URL)
+	for _, rule := range r.Rules {
+		fmt.Fprintf(w, "# rule: %s\n", rule)
+	}
+
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"group", "name", "count", "failed", "late", "dropped", "canceled", "bytes",
+		"min_ms", "p50_ms", "p90_ms", "p95_ms", "p99_ms", "p99_9_ms", "max_ms", "mean_ms", "stddev_ms", "rps"})
+
+	writeRow := func(group string, name string, s StatsReport) {
+		row := []string{group, name}
+		for _, v := range []uint64{s.Count, s.Failed, s.Late, s.Dropped, s.Canceled, s.Bytes} {
+			row = append(row, strconv.FormatUint(v, 10))
+		}
+		for _, v := range []float64{s.MinMs, s.P50Ms, s.P90Ms, s.P95Ms, s.P99Ms, s.P999Ms, s.MaxMs, s.MeanMs, s.StdDevMs, s.RPS} {
+			row = append(row, strconv.FormatFloat(v, 'f', 3, 64))
+		}
+		writer.Write(row)
+	}
+	writeRows := func(group string, m map[string]StatsReport) {
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			writeRow(group, k, m[k])
+		}
+	}
+
+	writeRow("total", "", r.Total)
+	writeRows("stage", r.Stages)
+	writeRows("class", r.Classes)
+	writeRows("cache", r.Cache)
+	writeRows("replay", r.Replay)
+	writeRows("phase", r.Phases)
+	writeRows("rule", r.ByRule)
+	writeRows("query", r.ByQuery)
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// WriteSummary writes summary in format to file, empty path means stdout
+func WriteSummary(summary *Summary, opts options, rules []Rule, format string, path string) error {
+	w := io.Writer(os.Stdout)
+	if path != "" {
+		file, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+
+	switch format {
+	case TextOutput:
+		summary.Print(w)
+		return nil
+	case JSONOutput:
+		return NewReport(summary, opts, rules).WriteJSON(w)
+	case CSVOutput:
+		return NewReport(summary, opts, rules).WriteCSV(w)
+	}
+	return fmt.Errorf("Unknown output format '%s'", format)
+}
+
+// LogEntry is a per-request log record, durations are in milliseconds
+type LogEntry struct {
+	Time       time.Time          `json:"time"`
+	URL        string             `json:"url"`
+	Query      string             `json:"query"`
+	Rule       string             `json:"rule"`
+	Stage      string             `json:"stage"`
+	Cache      string             `json:"cache,omitempty"`
+	From       time.Time          `json:"from"`
+	Until      time.Time          `json:"until"`
+	Status     string             `json:"status"`
+	StatusCode int                `json:"status_code,omitempty"`
+	Error      string             `json:"error,omitempty"`
+	Failed     bool               `json:"failed"`
+	Late       bool               `json:"late,omitempty"`
+	Dropped    bool               `json:"dropped,omitempty"`
+	ElapsedMs  float64            `json:"elapsed_ms"`
+	LoggedMs   float64            `json:"logged_ms,omitempty"`
+	PhasesMs   map[string]float64 `json:"phases_ms,omitempty"`
+	Bytes      int64              `json:"bytes"`
+}
+
+// NewLogEntry returns log record for request
+func NewLogEntry(request requestData) LogEntry {
+	entry := LogEntry{
+		Time:       request.Scheduled,
+		URL:        request.URL,
+		Query:      request.MetricName,
+		Rule:       request.Rule,
+		Stage:      request.Stage,
+		Cache:      request.Cache,
+		From:       request.From,
+		Until:      request.Until,
+		Status:     request.Status,
+		StatusCode: request.StatusCode,
+		Error:      request.Error,
+		Failed:     request.Failed,
+		Late:       request.Late,
+		Dropped:    request.Dropped,
+		ElapsedMs:  toMs(request.Elapsed),
+		LoggedMs:   toMs(request.Logged),
+		Bytes:      request.Bytes,
+	}
+	if !request.Dropped {
+		entry.PhasesMs = make(map[string]float64, len(Phases))
+		for _, phase := range Phases {
+			entry.PhasesMs[phase] = toMs(request.Timings.Get(phase))
+		}
+	}
+	return entry
+}
+
+// RequestLog writes per-request NDJSON log
+type RequestLog struct {
+	file    *os.File
+	writer  *bufio.Writer
+	encoder *json.Encoder
+}
+
+// NewRequestLog creates log file
+func NewRequestLog(path string) (*RequestLog, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	writer := bufio.NewWriter(file)
+	return &RequestLog{file, writer, json.NewEncoder(writer)}, nil
+}
+
+// Write appends request to log
+func (l *RequestLog) Write(request requestData) error {
+	return l.encoder.Encode(NewLogEntry(request))
+}
+
+// Close flushes and closes log file
+func (l *RequestLog) Close() error {
+	err := l.writer.Flush()
+	if err != nil {
+		l.file.Close()
+		return err
+	}
+	return l.file.Close()
+}
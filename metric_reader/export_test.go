@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func exportSummary() *Summary {
+	summary := NewSummary(10)
+	summary.Start = time.Unix(1600000000, 0).UTC()
+	summary.End = summary.Start.Add(10 * time.Second)
+
+	var request requestData
+	request.Rule = "a[1h]"
+	request.MetricName = "'dc=a'"
+	request.Stage = "main"
+	request.Cache = CacheCold
+	request.Scheduled = summary.Start
+	request.Status = "ok"
+	request.Elapsed = 10 * time.Millisecond
+	request.Timings.TTFB = 8 * time.Millisecond
+	request.Bytes = 100
+	summary.Add(request)
+
+	request.Status = "http_5xx"
+	request.Failed = true
+	request.Elapsed = 30 * time.Millisecond
+	summary.Add(request)
+	return summary
+}
+
+func TestReportJSON(t *testing.T) {
+	report := NewReport(exportSummary(), options{Source: "Carbon", URL: "http://localhost:8080"}, []Rule{{Name: "a[1h]"}})
+	var buf bytes.Buffer
+	if err := report.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded Report
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !decoded.Start.Equal(report.Start) || decoded.Options.URL != report.Options.URL || len(decoded.Rules) != 1 || decoded.Rules[0] != "a[1h]" {
+		t.Errorf("Not expected metadata: %+v", decoded)
+	}
+	if decoded.Total.Count != 2 || decoded.Total.Failed != 1 || decoded.Total.Bytes != 200 || decoded.Total.MaxMs != report.Total.MaxMs {
+		t.Errorf("Not expected total: %+v", decoded.Total)
+	}
+	if decoded.Stages["main"].Count != 2 || decoded.Classes["ok"].Count != 1 || decoded.Cache[CacheCold].Count != 2 ||
+		decoded.ByRule["a[1h]"].Count != 2 || decoded.ByQuery["'dc=a'"].Count != 2 || decoded.Phases[PhaseTTFB].Count != 2 {
+		t.Errorf("Not expected groups: %+v", decoded)
+	}
+}
+
+func TestReportCSV(t *testing.T) {
+	report := NewReport(exportSummary(), options{Source: "Carbon", URL: "http://localhost:8080"}, []Rule{{Name: "a[1h]"}})
+	var buf bytes.Buffer
+	if err := report.WriteCSV(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	var table bytes.Buffer
+	for _, line := range strings.SplitAfter(buf.String(), "\n") {
+		if !strings.HasPrefix(line, "#") {
+			table.WriteString(line)
+		}
+	}
+	if !strings.Contains(buf.String(), "# url: http://localhost:8080\n") || !strings.Contains(buf.String(), "# rule: a[1h]\n") {
+		t.Errorf("Not expected metadata: %s", buf.String())
+	}
+	rows, err := csv.NewReader(&table).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) == 0 || rows[0][0] != "group" || rows[0][2] != "count" {
+		t.Fatalf("Not expected header: %v", rows)
+	}
+
+	keys := make(map[string][]string)
+	for _, row := range rows[1:] {
+		if len(row) != len(rows[0]) {
+			t.Errorf("Not expected row length: %v", row)
+		}
+		keys[row[0]+"/"+row[1]] = row
+	}
+	for _, key := range []string{"total/", "stage/main", "class/ok", "class/http_5xx", "cache/" + CacheCold, "phase/" + PhaseTTFB, "rule/a[1h]", "query/'dc=a'"} {
+		if _, ok := keys[key]; !ok {
+			t.Errorf("No row %s in %v", key, rows)
+		}
+	}
+	if total := keys["total/"]; total == nil || total[2] != "2" || total[3] != "1" || total[7] != "200" {
+		t.Errorf("Not expected total row: %v", total)
+	}
+}
+
+func TestRequestLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log.ndjson")
+	requestLog, err := NewRequestLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var request requestData
+	request.URL = "http://localhost:8080/render?target=a"
+	request.Rule = "a[1h]"
+	request.MetricName = "a"
+	request.Scheduled = time.Unix(1600000000, 0).UTC()
+	request.Status = "ok"
+	request.Elapsed = 10 * time.Millisecond
+	request.Logged = 4 * time.Millisecond
+	request.Timings.TTFB = 8 * time.Millisecond
+	requestLog.Write(request)
+
+	request.Dropped = true
+	request.Status = "dropped"
+	requestLog.Write(request)
+	if err := requestLog.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	entries := make([]LogEntry, 0)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry LogEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("Not expected entries: %+v", entries)
+	}
+
+	entry := entries[0]
+	if !entry.Time.Equal(request.Scheduled) || entry.URL != request.URL || entry.Rule != "a[1h]" || entry.Query != "a" ||
+		entry.ElapsedMs != 10 || entry.LoggedMs != 4 || entry.PhasesMs[PhaseTTFB] != 8 || len(entry.PhasesMs) != len(Phases) {
+		t.Errorf("Not expected entry: %+v", entry)
+	}
+	if !entries[1].Dropped || entries[1].PhasesMs != nil {
+		t.Errorf("Not expected dropped entry: %+v", entries[1])
+	}
+}
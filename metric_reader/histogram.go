@@ -0,0 +1,218 @@
+package main
+
+import (
+	"math"
+	"math/bits"
+	"time"
+)
+
+// Histogram bucket layout: values below histSubBuckets are stored exactly,
+// every next power of two is split into histSubBuckets linear buckets,
+// so relative error stays below 1/histSubBuckets (~0.8%).
+const (
+	histSubBucketBits = 7
+	histSubBuckets    = 1 << histSubBucketBits
+	// histMaxValue caps recorded values to keep bucket count bounded
+	histMaxValue = int64(time.Hour)
+)
+
+// Histogram is a log-linear (HDR style) latency histogram with bounded memory
+type Histogram struct {
+	counts []uint64
+	count  uint64
+	min    int64
+	max    int64
+	sum    float64
+	sumSq  float64
+}
+
+// NewHistogram returns empty histogram
+func NewHistogram() *Histogram {
+	return &Histogram{min: math.MaxInt64}
+}
+
+func histBucketIndex(v int64) int {
+	if v < histSubBuckets {
+		return int(v)
+	}
+	shift := bits.Len64(uint64(v)) - histSubBucketBits - 1
+	return shift*histSubBuckets + int(v>>uint(shift))
+}
+
+func histBucketHigh(idx int) int64 {
+	shift := idx/histSubBuckets - 1
+	if shift < 0 {
+		shift = 0
+	}
+	m := int64(idx - shift*histSubBuckets)
+	return (m+1)<<uint(shift) - 1
+}
+
+// Record adds duration to histogram
+func (h *Histogram) Record(d time.Duration) {
+	v := int64(d)
+	if v < 0 {
+		v = 0
+	}
+	if v > histMaxValue {
+		v = histMaxValue
+	}
+
+	idx := histBucketIndex(v)
+	if idx >= len(h.counts) {
+		grown := make([]uint64, idx+1)
+		copy(grown, h.counts)
+		h.counts = grown
+	}
+	h.counts[idx]++
+
+	h.count++
+	if v < h.min {
+		h.min = v
+	}
+	if v > h.max {
+		h.max = v
+	}
+	f := float64(v)
+	h.sum += f
+	h.sumSq += f * f
+}
+
+// Merge adds all values from other histogram
+func (h *Histogram) Merge(other *Histogram) {
+	if other.count == 0 {
+		return
+	}
+	if len(other.counts) > len(h.counts) {
+		grown := make([]uint64, len(other.counts))
+		copy(grown, h.counts)
+		h.counts = grown
+	}
+	for i, c := range other.counts {
+		h.counts[i] += c
+	}
+	h.count += other.count
+	if other.min < h.min {
+		h.min = other.min
+	}
+	if other.max > h.max {
+		h.max = other.max
+	}
+	h.sum += other.sum
+	h.sumSq += other.sumSq
+}
+
+// Count returns number of recorded values
+func (h *Histogram) Count() uint64 {
+	return h.count
+}
+
+// Min returns minimal recorded value
+func (h *Histogram) Min() time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	return time.Duration(h.min)
+}
+
+// Max returns maximal recorded value
+func (h *Histogram) Max() time.Duration {
+	return time.Duration(h.max)
+}
+
+// Mean returns arithmetic mean of recorded values
+func (h *Histogram) Mean() time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	return time.Duration(h.sum / float64(h.count))
+}
+
+// StdDev returns standard deviation of recorded values
+func (h *Histogram) StdDev() time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	mean := h.sum / float64(h.count)
+	variance := h.sumSq/float64(h.count) - mean*mean
+	if variance < 0 {
+		variance = 0
+	}
+	return time.Duration(math.Sqrt(variance))
+}
+
+// Quantile returns value at quantile q (0..1)
+func (h *Histogram) Quantile(q float64) time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	target := uint64(math.Ceil(q * float64(h.count)))
+	if target == 0 {
+		target = 1
+	}
+
+	cumulative := uint64(0)
+	for i, c := range h.counts {
+		cumulative += c
+		if cumulative >= target {
+			v := histBucketHigh(i)
+			if v > h.max {
+				v = h.max
+			}
+			if v < h.min {
+				v = h.min
+			}
+			return time.Duration(v)
+		}
+	}
+	return time.Duration(h.max)
+}
+
+// MannWhitney compares distributions of two histograms with Mann-Whitney U test
+// (normal approximation, values within a bucket are ties).
+// Returns z score, positive if values of b tend to be greater than values of a,
+// and two-sided p-value
+func MannWhitney(a *Histogram, b *Histogram) (float64, float64) {
+	n1 := float64(a.count)
+	n2 := float64(b.count)
+	if n1 == 0 || n2 == 0 {
+		return 0, 1
+	}
+
+	// U counts pairs where value of b is greater than value of a, ties count as half
+	u := float64(0)
+	belowA := float64(0)
+	ties := float64(0)
+	size := len(a.counts)
+	if len(b.counts) > size {
+		size = len(b.counts)
+	}
+	for i := 0; i < size; i++ {
+		ca := float64(0)
+		if i < len(a.counts) {
+			ca = float64(a.counts[i])
+		}
+		cb := float64(0)
+		if i < len(b.counts) {
+			cb = float64(b.counts[i])
+		}
+		u += cb * (belowA + ca/2)
+		belowA += ca
+		t := ca + cb
+		ties += t*t*t - t
+	}
+
+	n := n1 + n2
+	mean := n1 * n2 / 2
+	variance := n1 * n2 / 12 * ((n + 1) - ties/(n*(n-1)))
+	if variance <= 0 {
+		return 0, 1
+	}
+	z := (u - mean) / math.Sqrt(variance)
+	return z, 2 * normalSF(math.Abs(z))
+}
+
+// normalSF is a survival function of standard normal distribution
+func normalSF(z float64) float64 {
+	return math.Erfc(z/math.Sqrt2) / 2
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHistogramQuantile(t *testing.T) {
+	h := NewHistogram()
+	for i := 1; i <= 1000; i++ {
+		h.Record(time.Duration(i) * time.Millisecond)
+	}
+
+	if h.Count() != 1000 {
+		t.Errorf("Count: %d != 1000", h.Count())
+	}
+	if h.Min() != time.Millisecond {
+		t.Errorf("Min: %s != 1ms", h.Min())
+	}
+	if h.Max() != time.Second {
+		t.Errorf("Max: %s != 1s", h.Max())
+	}
+
+	check := func(q float64, expected time.Duration) {
+		got := h.Quantile(q)
+		diff := got - expected
+		if diff < 0 {
+			diff = -diff
+		}
+		if float64(diff) > float64(expected)*0.01 {
+			t.Errorf("Quantile(%v): %s, expected ~%s", q, got, expected)
+		}
+	}
+	check(0.5, 500*time.Millisecond)
+	check(0.9, 900*time.Millisecond)
+	check(0.99, 990*time.Millisecond)
+	check(1, time.Second)
+}
+
+func TestHistogramMerge(t *testing.T) {
+	a := NewHistogram()
+	b := NewHistogram()
+	a.Record(10 * time.Millisecond)
+	b.Record(30 * time.Millisecond)
+	a.Merge(b)
+
+	if a.Count() != 2 {
+		t.Errorf("Count: %d != 2", a.Count())
+	}
+	if a.Mean() != 20*time.Millisecond {
+		t.Errorf("Mean: %s != 20ms", a.Mean())
+	}
+	if a.StdDev() != 10*time.Millisecond {
+		t.Errorf("StdDev: %s != 10ms", a.StdDev())
+	}
+}
+
+func TestHistogramSmallValues(t *testing.T) {
+	h := NewHistogram()
+	for i := int64(0); i < histSubBuckets*4; i++ {
+		if histBucketHigh(histBucketIndex(i)) < i {
+			t.Errorf("Bucket for %d has upper bound %d", i, histBucketHigh(histBucketIndex(i)))
+		}
+		h.Record(time.Duration(i))
+	}
+	if h.Quantile(0) != 0 {
+		t.Errorf("Quantile(0): %d != 0", h.Quantile(0))
+	}
+}
+
+func TestMannWhitney(t *testing.T) {
+	a := NewHistogram()
+	b := NewHistogram()
+	c := NewHistogram()
+	for i := 0; i < 200; i++ {
+		a.Record(time.Duration(100+i%50) * time.Millisecond)
+		b.Record(time.Duration(100+i%50) * time.Millisecond)
+		c.Record(time.Duration(130+i%50) * time.Millisecond)
+	}
+
+	if z, p := MannWhitney(a, b); z != 0 || p < 0.99 {
+		t.Errorf("Same distributions: z=%v p=%v", z, p)
+	}
+	if z, p := MannWhitney(a, c); z <= 0 || p > 0.001 {
+		t.Errorf("Shifted distributions: z=%v p=%v", z, p)
+	}
+	if z, _ := MannWhitney(c, a); z >= 0 {
+		t.Errorf("Reverse shifted distributions: z=%v", z)
+	}
+}
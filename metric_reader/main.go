@@ -2,34 +2,51 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
 	"math/rand"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
 	"time"
 
+	"github.com/ifireice/metric_reader/metric_reader/backend"
 	"github.com/ifireice/metric_reader/metric_reader/carbon"
-	"github.com/ifireice/metric_reader/metric_reader/prometheus"
-)
-
-// Source constants
-const (
-	PROMETHEUS = "Prometheus"
-	CARBON     = "Carbon"
 )
 
 type requestData struct {
+	Method     string
 	URL        string
+	Header     http.Header
+	Body       []byte
+	Query      backend.Query
+	Cache      string // CacheCold OR CacheWarm
+	Key        string // identity of generated request in replay cache
 	MetricName string
+	Rule       string
+	From       time.Time
+	Until      time.Time
+	Stage      string
+	Scheduled  time.Time     // intended send time, zero in closed-loop mode
+	Logged     time.Duration // query time logged by backend of replayed request
 	Elapsed    time.Duration
+	Timings    Timings
+	Bytes      int64
+	Status     string // outcome class
+	StatusCode int
+	Error      string
 	Failed     bool
-}
-
-type average struct {
-	Elapsed time.Duration
-	Count   uint64
+	Late       bool
+	Dropped    bool
 }
 
 func generataRandomTagQuery(metrics map[string][]string) string {
@@ -48,109 +65,221 @@ func generataRandomTagQuery(metrics map[string][]string) string {
 	return ret.String()
 }
 
-func getFromUntil(minFrom time.Time, period time.Duration) (time.Time, time.Time) {
-	minF := minFrom.Unix()
-	maxF := time.Now().Unix()
-
-	randUnixTime := rand.Int63n(maxF-minF) + minF
-	randTime := time.Unix(randUnixTime, 0)
-
-	from := randTime
-	until := randTime.Add(period)
-
-	return from, until
-}
+// generateRequests sends count requests (inf if 0) to outChan, new ones or repeated from cache.
+// Random choices are drawn from r, rules and cache, so the stream is the same for the same seeds and corpus.
+// Absolute ranges depend on start time, cache keeps requests by ranges relative to generation time
+func generateRequests(ctx context.Context, b backend.Backend, series SeriesSource, rules *RulePicker, cache *ReplayCache, window *TimeWindow, count uint64, maxPeriod time.Duration, maxDataPoints int, r *rand.Rand, outChan chan requestData) error {
+	defer close(outChan)
+
+	sent := uint64(0)
+	send := func(request requestData, repeat bool) bool {
+		request.Cache = CacheCold
+		if repeat && cache.Bust == NoBust {
+			request.Cache = CacheWarm
+		}
+		if cache.Bust != NoBust {
+			request = BustRequest(b, request, cache.Bust, r)
+		}
+		select {
+		case outChan <- request:
+			sent++
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
 
-func generateRequests(url string, metrics []string, rules []Rule, count uint64, maxPeriod time.Duration, getURLFunc func(string, string, time.Time, time.Time) string, outChan chan requestData) error {
-	cache := make(map[string]requestData, 0)
-	i := uint64(0)
 	for {
-		coin := rand.Int63n(4)
-		if coin > 0 && len(cache) != 0 {
-			keys := make([]string, 0)
-			for k := range cache {
-				keys = append(keys, k)
+		if ctx.Err() != nil || (count > 0 && sent >= count) {
+			return nil
+		}
+
+		if cached, ok := cache.Next(); ok {
+			if !send(cached, true) {
+				return nil
 			}
-			kn := rand.Int63n(int64(len(keys)))
-			outChan <- cache[keys[kn]]
 			continue
 		}
 
-		ruleN := rand.Int63n(int64(len(rules)))
-		// metricN := rand.Int63n(int64(len(metrics)))
-		metric, err := carbon.GetRandomTags(url)
+		rule := rules.Pick()
+		ruleWindow := window
+		if rule.Window != nil {
+			ruleWindow = rule.Window
+		}
+		now := time.Now().Truncate(time.Second)
+		period := rule.RandomPeriod(r)
+		from, until := ruleWindow.Range(now, maxPeriod, period, r)
+		offset := now.Sub(until)
+		// aligned range moves with clock, it is the same request at any time
+		if ruleWindow.Name == AlignedWindow {
+			offset = 0
+		}
+
+		backendQuery := backend.Query{
+			From:          from,
+			Until:         until,
+			Range:         rule.Range,
+			MaxDataPoints: maxDataPoints,
+			Format:        rule.Format,
+			Step:          rule.Step,
+		}
+		if rule.MaxDataPoints > 0 {
+			backendQuery.MaxDataPoints = rule.MaxDataPoints
+		}
+
+		query, err := ExpandTemplate(rule.MetricQueryTemplate, NewTemplateVars(series, b, until.Sub(from), b.Interval(backendQuery)))
 		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while picking series: %s\n", err)
 			return err
 		}
+		backendQuery.Expr = query
 
-		query := Template2Metric(rules[ruleN].MetricQueryTemplate, metric)
-		fmt.Printf(">>>>>>%s %s %s", metric, query, rules[ruleN])
-
-		minTime := time.Now().Add(-maxPeriod)
-		from, until := getFromUntil(minTime, rules[ruleN].Period)
+		built := b.BuildRequest(backendQuery)
 
 		var request requestData
-		request.URL = getURLFunc(url, query, from, until)
+		request.Method = built.Method
+		request.URL = built.URL
+		request.Header = built.Header
+		request.Body = built.Body
+		request.Query = backendQuery
 		request.MetricName = query
+		request.Rule = rule.Label()
+		request.From = from
+		request.Until = until
 		request.Failed = false
+		request.Key = CacheKey(request, offset, period)
 
-		cache[request.URL] = request
-		outChan <- request
-
-		if count > 0 {
-			if i >= count {
-				break
-			}
-			i++
+		cache.Add(request)
+		if !send(request, false) {
+			return nil
 		}
 	}
-	close(outChan)
-	return nil
 }
 
-func makeHTTPRequest(inChan chan requestData, resultChan chan requestData, doneChan chan bool) {
+// makeHTTPRequest sends requests from inChan until it is closed or ctx is done.
+// Requests in flight are sent with drainCtx, so they can finish after ctx is done
+func makeHTTPRequest(ctx context.Context, drainCtx context.Context, worker int, profile *LoadProfile, b backend.Backend, metrics *Metrics, inChan chan requestData, resultChan chan requestData, doneChan chan bool) {
 	timeout := time.Duration(30 * time.Second)
 	client := http.Client{
 		Timeout: timeout,
 	}
 
 	for {
-		request, more := <-inChan
+		if !profile.WaitActive(ctx, worker) {
+			doneChan <- true
+			return
+		}
+
+		var request requestData
+		more := false
+		select {
+		case request, more = <-inChan:
+		case <-ctx.Done():
+		}
 		if !more {
 			doneChan <- true
 			return
 		}
+		if ctx.Err() != nil {
+			resultChan <- cancelRequest(profile, request)
+			doneChan <- true
+			return
+		}
 
 		start := time.Now()
-		resp, err := client.Get(request.URL)
-		if err != nil {
-			fmt.Printf("%s failed\n", request.URL)
-			request.Failed = true
-			resultChan <- request
-			continue
+		if request.Scheduled.IsZero() {
+			request.Scheduled = start
 		}
+		request.Stage = profile.At(request.Scheduled).Name
+		request.Late = start.Sub(request.Scheduled) > lateThreshold
+
+		metrics.Started()
+		request = doRequest(drainCtx, &client, b, request)
+		metrics.Finished()
+		resultChan <- request
+	}
+}
 
-		// body, err := ioutil.ReadAll(resp.Body)
-		// _, err = ioutil.ReadAll(resp.Body)
+// newDrainContext returns context of requests in flight, it is done after timeout since ctx is done
+func newDrainContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	drainCtx, cancelDrain := context.WithCancel(context.Background())
+	go func() {
+		<-ctx.Done()
+		time.Sleep(timeout)
+		cancelDrain()
+	}()
+	return drainCtx, cancelDrain
+}
+
+// drainQueue reports requests left in inChan on shutdown as canceled, so every generated request is accounted.
+// Returns when inChan is closed
+func drainQueue(profile *LoadProfile, inChan chan requestData, resultChan chan requestData) {
+	for request := range inChan {
+		resultChan <- cancelRequest(profile, request)
+	}
+}
+
+// cancelRequest returns request which was not sent on shutdown
+func cancelRequest(profile *LoadProfile, request requestData) requestData {
+	if request.Scheduled.IsZero() {
+		request.Scheduled = time.Now()
+	}
+	request.Stage = profile.At(request.Scheduled).Name
+	request.Status = backend.Canceled
+	return request
+}
+
+// doRequest sends request and classifies its outcome
+func doRequest(ctx context.Context, client *http.Client, b backend.Backend, request requestData) requestData {
+	start := time.Now()
+	trace := newTracer(start)
+
+	// measured from intended send time to correct coordinated omission
+	finish := func(class string, err error) requestData {
+		request.Elapsed = time.Since(request.Scheduled)
+		request.Timings = trace.done()
+		request.Timings.Wait = start.Sub(request.Scheduled)
+		request.Status = class
+		request.Failed = !backend.IsSuccess(class) && class != backend.Canceled
 		if err != nil {
-			fmt.Printf("%s read failed\n", request.URL)
-			request.Failed = true
-			resultChan <- request
-			continue
+			request.Error = err.Error()
+			fmt.Fprintf(os.Stderr, "%s %s: %s\n", request.URL, class, err)
 		}
-		resp.Body.Close()
+		return request
+	}
+
+	httpRequest, err := http.NewRequestWithContext(ctx, request.Method, request.URL, bytes.NewReader(request.Body))
+	if err != nil {
+		return finish(backend.TransportError, err)
+	}
+	for k, v := range request.Header {
+		httpRequest.Header[k] = v
+	}
 
-		fmt.Println("====")
-		fmt.Println(request.URL)
-		// fmt.Println(string(body))
-		fmt.Println("====")
+	resp, err := client.Do(trace.withTrace(httpRequest))
+	if err != nil {
+		return finish(transportClass(ctx, err), err)
+	}
 
-		t := time.Now()
-		request.Elapsed = t.Sub(start)
-		request.Failed = false
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	request.Bytes = int64(len(body))
+	if err != nil {
+		return finish(transportClass(ctx, err), err)
+	}
+	request.StatusCode = resp.StatusCode
 
-		resultChan <- request
+	return finish(b.Validate(request.Query, resp.StatusCode, body))
+}
+
+func transportClass(ctx context.Context, err error) string {
+	if ctx.Err() != nil {
+		return backend.Canceled
 	}
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return backend.Timeout
+	}
+	return backend.TransportError
 }
 
 func resultPrinter(resultChan chan requestData, doneChan chan bool) {
@@ -164,34 +293,55 @@ func resultPrinter(resultChan chan requestData, doneChan chan bool) {
 	doneChan <- true
 }
 
-func resultAverage(resultChan chan requestData, doneChan chan bool) {
-	var averageRes average
-	metricAvrg := make(map[string]average)
-	failedCount := uint64(0)
+func resultSummary(resultChan chan requestData, doneChan chan bool, summary *Summary, requestLog *RequestLog, recorder *Recorder, slo *SLOMonitor, progress *Progress, metrics *Metrics) {
+	// nil channel never fires, so without progress only results are received
+	var tick <-chan time.Time
+	if progress != nil {
+		ticker := time.NewTicker(progress.Interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
 	for {
-		result, more := <-resultChan
+		var result requestData
+		more := true
+		select {
+		case result, more = <-resultChan:
+		case now := <-tick:
+			err := progress.Report(now)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error while writing progress: %s\n", err)
+			}
+			continue
+		}
 		if !more {
 			break
 		}
-
-		if result.Failed {
-			failedCount++
+		if progress != nil {
+			progress.Add(result)
+		}
+		summary.Add(result)
+		metrics.Add(result)
+		slo.Observe(summary)
+		if requestLog != nil {
+			err := requestLog.Write(result)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error while writing request log: %s\n", err)
+			}
+		}
+		if recorder != nil {
+			err := recorder.Write(result)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error while writing record: %s\n", err)
+			}
 		}
-
-		averageRes.Count++
-		averageRes.Elapsed += result.Elapsed
-
-		ma := metricAvrg[result.MetricName]
-		ma.Count++
-		ma.Elapsed += result.Elapsed
-		metricAvrg[result.MetricName] = ma
 	}
-	fmt.Printf("Average %d nanoseconds\n", (averageRes.Elapsed.Nanoseconds() / int64(averageRes.Count)))
-	fmt.Printf("Failed count: %d\n", failedCount)
-	fmt.Println()
-
-	for k, v := range metricAvrg {
-		fmt.Printf("%s: %d nanoseconds\n", k, (v.Elapsed.Nanoseconds() / int64(v.Count)))
+	summary.End = time.Now()
+	if progress != nil {
+		err := progress.Report(summary.End)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while writing progress: %s\n", err)
+		}
 	}
 
 	doneChan <- true
@@ -206,15 +356,86 @@ func getEnv(name string, defVal string) string {
 }
 
 type options struct {
-	Source        string // "Prometheus" OR "Carbon"
-	URL           string
-	Count         uint64
-	ParallelCount uint64
-	RulesPath     string
-	PeriodStr     string
+	Source           string // "Prometheus" OR "Carbon"
+	URL              string
+	Count            uint64
+	ParallelCount    uint64
+	RulesPath        string
+	PeriodStr        string
+	MaxQueries       int
+	Rate             float64
+	Poisson          bool
+	MaxBacklog       int
+	Duration         time.Duration
+	Warmup           time.Duration
+	StagesStr        string
+	MaxDataPoints    int
+	DiscoverPath     string
+	DiscoverPar      int
+	CorpusPath       string
+	Output           string
+	OutputFile       string
+	LogPath          string
+	SLO              string
+	SLOFile          string
+	SLOInterval      time.Duration
+	SLOAbort         bool
+	DrainTimeout     time.Duration
+	Progress         time.Duration
+	ProgressRules    bool
+	ProgressLog      string
+	MetricsAddr      string
+	GraphiteAddr     string
+	GraphitePrefix   string
+	GraphiteProgress bool
+	RunID            string
+	Repeat           float64
+	CacheSize        int
+	CacheBust        string
+	SeriesDist       string
+	Window           string
+	Seed             int64
+	RecordPath       string
+	ReplayPath       string
+	ReplayFormat     string
+	ReplayRules      bool
+	ReplaySpeed      float64
+}
+
+// Exit codes
+const (
+	ExitOK         = 0
+	ExitError      = 1
+	ExitSLOFailed  = 3
+	ExitSLOAborted = 4
+	ExitRegression = 5
+	ExitAllFailed  = 6
+	// ExitInterrupted is the shell convention for SIGINT
+	ExitInterrupted = 130
+)
+
+// subcommands are run as 'metric_reader <name> [options]'
+var subcommands = map[string]func([]string) error{
+	"analyze": runAnalyze,
+	"compare": runCompare,
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		if run, ok := subcommands[os.Args[1]]; ok {
+			err := run(os.Args[2:])
+			if err == ErrRegression {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(ExitRegression)
+			}
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(ExitError)
+			}
+			return
+		}
+	}
+
 	requestsChan := make(chan requestData)
 	resultsChan := make(chan requestData)
 	doneChan := make(chan bool)
@@ -231,71 +452,377 @@ func main() {
 	}
 
 	var opts options
-	flag.StringVar(&opts.Source, "source", getEnv("SOURCE", CARBON), "Source type: Prometeus OR Carbon")
+	flag.StringVar(&opts.Source, "source", getEnv("SOURCE", carbon.Name), fmt.Sprintf("Source type: %s", strings.Join(backend.Names(), " OR ")))
 	flag.StringVar(&opts.URL, "url", getEnv("PROM_URL", defaultPromURL), fmt.Sprintf("URL, default:%s", defaultPromURL))
 	flag.Uint64Var(&opts.Count, "count", defaultCount, fmt.Sprintf("Number of requests, default: inf"))
 	flag.Uint64Var(&opts.ParallelCount, "parallel", defaultParCount, fmt.Sprintf("Number of parallel requests, default: 10"))
 	flag.StringVar(&opts.RulesPath, "rules", getEnv("RULES_PATH", ""), fmt.Sprintf("Path to rules file"))
 	flag.StringVar(&opts.PeriodStr, "period", getEnv("PERIOD", "168h"), fmt.Sprintf("Max period for metrics, default 168h (one week)"))
+	flag.IntVar(&opts.MaxQueries, "max_queries", 1000, fmt.Sprintf("Max number of distinct queries in summary, default: 1000"))
+	flag.Float64Var(&opts.Rate, "rate", 0, fmt.Sprintf("Target requests per second (open model), default: 0 (closed loop)"))
+	flag.BoolVar(&opts.Poisson, "poisson", false, fmt.Sprintf("Use Poisson arrivals with -rate, default: false"))
+	flag.IntVar(&opts.MaxBacklog, "max_backlog", 1000, fmt.Sprintf("Max scheduled requests waiting for worker with -rate, default: 1000"))
+	flag.DurationVar(&opts.Duration, "duration", 0, fmt.Sprintf("Max duration of the run, default: inf (sum of warmup and stages if set)"))
+	flag.DurationVar(&opts.Warmup, "warmup", 0, fmt.Sprintf("Warm-up period excluded from stats, default: 0"))
+	flag.StringVar(&opts.StagesStr, "stages", getEnv("STAGES", ""), fmt.Sprintf("Load stages 'duration:level,...', level is workers or rps with -rate, e.g. 2m:10,2m:50,2m:100"))
+	flag.IntVar(&opts.MaxDataPoints, "max_data_points", 0, fmt.Sprintf("Max data points per query: Prometheus range query step (default: 1000) and Carbon maxDataPoints (default: not sent)"))
+	flag.StringVar(&opts.DiscoverPath, "discover", "", fmt.Sprintf("Crawl all series, save corpus to this file and exit"))
+	flag.IntVar(&opts.DiscoverPar, "discover_parallel", 10, fmt.Sprintf("Number of parallel discovery requests, default: 10"))
+	flag.StringVar(&opts.CorpusPath, "corpus", getEnv("CORPUS_PATH", ""), fmt.Sprintf("Path to corpus file, default: discover random series on every request"))
+	flag.StringVar(&opts.Output, "output", getEnv("OUTPUT", TextOutput), fmt.Sprintf("Summary format: text, json OR csv, default: text"))
+	flag.StringVar(&opts.OutputFile, "output_file", "", fmt.Sprintf("Path to summary file, default: stdout"))
+	flag.StringVar(&opts.LogPath, "log", "", fmt.Sprintf("Path to per-request NDJSON log, default: no log"))
+	flag.StringVar(&opts.SLO, "slo", getEnv("SLO", ""), fmt.Sprintf("';' separated assertions 'metric op value [for rule_regexp]', e.g. 'p99<500ms;error_rate<1%%;rps>200'"))
+	flag.StringVar(&opts.SLOFile, "slo_file", "", fmt.Sprintf("Path to assertions file, one assertion per line"))
+	flag.DurationVar(&opts.SLOInterval, "slo_interval", 0, fmt.Sprintf("Check assertions during the run every interval, default: only at the end"))
+	flag.BoolVar(&opts.SLOAbort, "slo_abort", false, fmt.Sprintf("Stop the run on the first breach found by -slo_interval checks"))
+	flag.DurationVar(&opts.Progress, "progress", 0, fmt.Sprintf("Report stats of the last interval every interval, e.g. 10s, default: only at the end"))
+	flag.BoolVar(&opts.ProgressRules, "progress_rules", false, fmt.Sprintf("Report progress per rule"))
+	flag.StringVar(&opts.ProgressLog, "progress_log", "", fmt.Sprintf("Path to NDJSON progress stream, default interval 10s, default: no stream"))
+	flag.StringVar(&opts.MetricsAddr, "metrics_addr", getEnv("METRICS_ADDR", ""), fmt.Sprintf("Listen address for Prometheus /metrics of the tester itself, e.g. :9200, default: disabled"))
+	flag.StringVar(&opts.GraphiteAddr, "graphite_addr", getEnv("GRAPHITE_ADDR", ""), fmt.Sprintf("Carbon plaintext address to push results to, e.g. localhost:2003, default: disabled"))
+	flag.StringVar(&opts.GraphitePrefix, "graphite_prefix", getEnv("GRAPHITE_PREFIX", "metric_reader"), fmt.Sprintf("Prefix of pushed metrics, default: metric_reader"))
+	flag.BoolVar(&opts.GraphiteProgress, "graphite_progress", false, fmt.Sprintf("Push interval stats too, interval is -progress or 10s"))
+	flag.StringVar(&opts.RunID, "run_id", getEnv("RUN_ID", ""), fmt.Sprintf("Run id tag of pushed metrics, default: start time"))
+	flag.Float64Var(&opts.Repeat, "repeat", 0.75, fmt.Sprintf("Probability to repeat earlier request (backend cache hit), default: 0.75"))
+	flag.IntVar(&opts.CacheSize, "cache_size", 10000, fmt.Sprintf("Max number of requests kept to repeat, least recently used are evicted, default: 10000"))
+	flag.StringVar(&opts.CacheBust, "cache_bust", "", fmt.Sprintf("Cache busting: time (shift time range) OR nonce (add random param), default: none"))
+	flag.StringVar(&opts.SeriesDist, "series_dist", getEnv("SERIES_DIST", UniformDist), fmt.Sprintf("Series picks over -corpus: uniform, zipf:skew (e.g. zipf:1.2) OR hotset:size:fraction (e.g. hotset:0.01:0.9), default: uniform"))
+	flag.StringVar(&opts.Window, "window", getEnv("WINDOW", UniformWindow), fmt.Sprintf("Query time range: now, uniform (within -period), recent:mean, aligned:step OR retention:age, default: uniform"))
+	flag.Int64Var(&opts.Seed, "seed", 0, fmt.Sprintf("Random seed, the same seed and -corpus give the same requests up to start time, use -record for exact ranges, default: random"))
+	flag.StringVar(&opts.RecordPath, "record", "", fmt.Sprintf("Path to NDJSON file to record sent requests to, default: no record"))
+	flag.StringVar(&opts.ReplayPath, "replay", "", fmt.Sprintf("Path to record to resend instead of generating requests"))
+	flag.StringVar(&opts.ReplayFormat, "replay_format", RecordReplay, fmt.Sprintf("Format of -replay: record (written by -record), carbonapi (JSON access log), graphite-web, nginx (combined access log) OR prometheus (query_log_file), default: record"))
+	flag.BoolVar(&opts.ReplayRules, "replay_rules", false, fmt.Sprintf("Replay rule evaluations of Prometheus query log too, default: API queries only"))
+	flag.Float64Var(&opts.ReplaySpeed, "replay_speed", 1, fmt.Sprintf("Speed multiplier of -replay timing, 0 sends as fast as workers allow, default: 1"))
+	flag.DurationVar(&opts.DrainTimeout, "drain_timeout", 30*time.Second, fmt.Sprintf("Time to wait for requests in flight on stop, default: 30s"))
 	flag.Parse()
 
-	fmt.Printf("Source:%s\n", opts.Source)
-	fmt.Printf("URL:%s\n", opts.URL)
-	fmt.Printf("Count:%d\n", opts.Count)
-	fmt.Printf("Parallel count:%d\n", opts.ParallelCount)
-	fmt.Printf("Rules path:%s\n", opts.RulesPath)
-	fmt.Printf("Period:%s\n", opts.PeriodStr)
+	if opts.Output != TextOutput && opts.Output != JSONOutput && opts.Output != CSVOutput {
+		panic(fmt.Sprintf("Unknown output format '%s'", opts.Output))
+	}
+	if err := CheckPushOptions(opts.GraphiteAddr, opts.GraphiteProgress); err != nil {
+		panic(err)
+	}
 
-	if opts.Source != "Prometheus" && opts.Source != "Carbon" {
-		panic("Source should be 'Prometheus' OR 'Carbon")
+	// keep stdout clean for machine readable summary
+	info := io.Writer(os.Stdout)
+	if opts.Output != TextOutput && opts.OutputFile == "" {
+		info = os.Stderr
 	}
 
-	maxPeriod, err := time.ParseDuration(opts.PeriodStr)
+	fmt.Fprintf(info, "Source:%s\n", opts.Source)
+	fmt.Fprintf(info, "URL:%s\n", opts.URL)
+	fmt.Fprintf(info, "Count:%d\n", opts.Count)
+	fmt.Fprintf(info, "Parallel count:%d\n", opts.ParallelCount)
+	fmt.Fprintf(info, "Rules path:%s\n", opts.RulesPath)
+	fmt.Fprintf(info, "Period:%s\n", opts.PeriodStr)
+	if opts.Rate > 0 {
+		fmt.Fprintf(info, "Rate:%.2f rps (poisson: %v)\n", opts.Rate, opts.Poisson)
+	}
+	fmt.Fprintf(info, "Duration:%s\n", opts.Duration)
+	fmt.Fprintf(info, "Warmup:%s\n", opts.Warmup)
+	fmt.Fprintf(info, "Stages:%s\n", opts.StagesStr)
+
+	b, err := backend.New(opts.Source, opts.URL)
+	if err != nil {
+		panic(err)
+	}
+
+	if opts.DiscoverPath != "" {
+		fmt.Fprintln(info, "Collecting all series ...")
+		corpus, err := DiscoverCorpus(b, opts.URL, opts.DiscoverPar)
+		if err != nil {
+			panic(err)
+		}
+		err = corpus.Write(opts.DiscoverPath)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Fprintf(info, "Collecting all series ... DONE, %d series saved to %s\n", len(corpus.Series), opts.DiscoverPath)
+		return
+	}
+
+	if opts.Seed == 0 {
+		opts.Seed = time.Now().UnixNano()
+	}
+	fmt.Fprintf(info, "Seed:%d\n", opts.Seed)
+	random := rand.New(rand.NewSource(opts.Seed))
+
+	seriesDist, err := ParseSeriesDist(opts.SeriesDist)
 	if err != nil {
 		panic(err)
 	}
+	if seriesDist.Name != UniformDist && opts.CorpusPath == "" {
+		panic("Series distribution needs -corpus")
+	}
+
+	var series SeriesSource = b
+	if opts.CorpusPath != "" {
+		corpus, err := ReadCorpus(opts.CorpusPath)
+		if err != nil {
+			fmt.Fprintf(info, "Error while reading corpus:%s", opts.CorpusPath)
+			panic(err)
+		}
+		if corpus.Source != b.Name() {
+			panic(fmt.Sprintf("Corpus source '%s' differs from '%s'", corpus.Source, b.Name()))
+		}
+		fmt.Fprintf(info, "Corpus:%s (%d series)\n", opts.CorpusPath, len(corpus.Series))
+		corpus.Index(b)
+		corpus.SetDist(seriesDist, random)
+		fmt.Fprintf(info, "Series distribution:%s\n", seriesDist)
+		series = corpus
+	}
 
-	// getAllMetricsFunc := prometheus.GetAllMetrics
-	getURLFunc := prometheus.GetURL
-	if opts.Source == CARBON {
-		// getAllMetricsFunc = carbon.GetAllMetrics
-		getURLFunc = carbon.GetURL
+	maxPeriod, err := time.ParseDuration(opts.PeriodStr)
+	if err != nil {
+		panic(err)
 	}
 
 	var rules []Rule
 	if opts.RulesPath != "" {
 		rules, err = ReadRules(opts.RulesPath)
 		if err != nil {
-			fmt.Printf("Error while parsing file:%s", opts.RulesPath)
+			fmt.Fprintf(info, "Error while parsing file:%s", opts.RulesPath)
 			panic(err)
 		}
 	} else {
 		rules = []Rule{GetDefaultRule()}
 	}
+	rules = FilterRules(rules, b.Name())
+	if len(rules) == 0 {
+		panic(fmt.Sprintf("No rules with positive weight for source %s", b.Name()))
+	}
+
+	assertions, err := ParseAssertions(opts.SLO)
+	if err != nil {
+		panic(err)
+	}
+	if opts.SLOFile != "" {
+		fileAssertions, err := ReadAssertions(opts.SLOFile)
+		if err != nil {
+			fmt.Fprintf(info, "Error while parsing file:%s", opts.SLOFile)
+			panic(err)
+		}
+		assertions = append(assertions, fileAssertions...)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	slo := NewSLOMonitor(assertions, opts.SLOInterval, opts.SLOAbort, cancel)
+
+	stages, err := ParseStages(opts.StagesStr)
+	if err != nil {
+		panic(err)
+	}
+
+	defaultLevel := float64(opts.ParallelCount)
+	if opts.Rate > 0 {
+		defaultLevel = opts.Rate
+	}
+	profile := NewLoadProfile(stages, opts.Warmup, defaultLevel)
+	profile.LimitWorkers = opts.Rate == 0
+
+	workersCount := int(opts.ParallelCount)
+	if opts.Rate == 0 {
+		workersCount = int(math.Ceil(profile.MaxLevel()))
+	}
+
+	if opts.Duration > 0 {
+		ctx, cancel = context.WithDeadline(ctx, profile.Start.Add(opts.Duration))
+		defer cancel()
+	} else if len(stages) > 0 {
+		ctx, cancel = context.WithDeadline(ctx, profile.Start.Add(profile.Total()))
+		defer cancel()
+	}
+
+	// first signal stops the run, requests in flight get drain timeout to finish,
+	// second signal exits immediately
+	var interrupted int32
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		atomic.StoreInt32(&interrupted, 1)
+		fmt.Fprintf(os.Stderr, "Got %s, stopping. Waiting up to %s for requests in flight, repeat to exit now\n", sig, opts.DrainTimeout)
+		cancel()
+		<-signals
+		os.Exit(ExitInterrupted)
+	}()
+
+	drainCtx, cancelDrain := newDrainContext(ctx, opts.DrainTimeout)
+	defer cancelDrain()
+
+	cacheBust, err := ParseBust(opts.CacheBust)
+	if err != nil {
+		panic(err)
+	}
+	if opts.Repeat < 0 || opts.Repeat > 1 {
+		panic(fmt.Sprintf("Repeat probability %g is out of [0, 1]", opts.Repeat))
+	}
+	cache := NewReplayCache(opts.Repeat, opts.CacheSize, cacheBust, random)
+	window, err := ParseTimeWindow(opts.Window)
+	if err != nil {
+		panic(err)
+	}
+
+	running := workersCount
+	workChan := requestsChan
+	if opts.ReplayPath != "" {
+		if opts.Rate > 0 {
+			panic("Replay keeps recorded timing, it can't be used with -rate")
+		}
+		var records []RecordEntry
+		skipped := 0
+		if opts.ReplayFormat == RecordReplay {
+			records, err = ReadRecords(opts.ReplayPath)
+		} else {
+			if source := ReplaySource(opts.ReplayFormat); source != "" && b.Name() != source {
+				panic(fmt.Sprintf("Replay format %s is replayed to %s only", opts.ReplayFormat, source))
+			}
+			records, skipped, err = ReadAccessLog(opts.ReplayPath, opts.ReplayFormat, opts.ReplayRules)
+		}
+		if err != nil {
+			fmt.Fprintf(info, "Error while reading replay:%s", opts.ReplayPath)
+			panic(err)
+		}
+		fmt.Fprintf(info, "Replay:%s (%d requests, %d lines skipped, speed: %g)\n", opts.ReplayPath, len(records), skipped, opts.ReplaySpeed)
+		if opts.ReplaySpeed > 0 {
+			workChan = make(chan requestData, opts.MaxBacklog)
+		}
+		go replayRequests(ctx, profile, b, records, opts.URL, opts.ReplaySpeed, opts.Count, workChan, resultsChan, doneChan)
+		running++
+	} else {
+		fmt.Fprintf(info, "Time window:%s\n", window)
+		go generateRequests(ctx, b, series, NewRulePicker(rules, random), cache, window, opts.Count, maxPeriod, opts.MaxDataPoints, random, requestsChan)
+		if opts.Rate > 0 {
+			workChan = make(chan requestData, opts.MaxBacklog)
+			// rand.Rand is not safe for concurrent use, scheduler gets its own
+			go scheduleRequests(ctx, profile, requestsChan, workChan, resultsChan, doneChan, opts.Poisson, rand.New(rand.NewSource(opts.Seed+1)))
+			running++
+		}
+	}
 
-	// fmt.Println("Collecting all metrics ...")
-	// metrics, err := getAllMetricsFunc(opts.URL)
-	// if err != nil {
-	// 	panic(err)
-	// }
-	// fmt.Println(metrics)
-	// fmt.Println("Collecting all metrics ... DONE")
-	metrics := make([]string, 0)
+	var metrics *Metrics
+	if opts.MetricsAddr != "" {
+		metrics = NewMetrics(b.Name(), func() int { return len(workChan) })
+		err = metrics.Listen(opts.MetricsAddr)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Fprintf(info, "Metrics address:%s\n", opts.MetricsAddr)
+	}
+
+	for i := 0; i < workersCount; i++ {
+		go makeHTTPRequest(ctx, drainCtx, i, profile, b, metrics, workChan, resultsChan, doneChan)
+	}
+
+	var requestLog *RequestLog
+	if opts.LogPath != "" {
+		requestLog, err = NewRequestLog(opts.LogPath)
+		if err != nil {
+			panic(err)
+		}
+	}
 
-	go generateRequests(opts.URL, metrics, rules, opts.Count, maxPeriod, getURLFunc, requestsChan)
+	var pusher *GraphitePusher
+	if opts.GraphiteAddr != "" {
+		if opts.RunID == "" {
+			opts.RunID = profile.Start.Format("20060102T150405")
+		}
+		pusher = NewGraphitePusher(opts.GraphiteAddr, opts.GraphitePrefix, opts.RunID, b.Name())
+		fmt.Fprintf(info, "Graphite:%s run_id=%s\n", opts.GraphiteAddr, opts.RunID)
+	}
 
-	for i := uint64(0); i < opts.ParallelCount; i++ {
-		go makeHTTPRequest(requestsChan, resultsChan, doneChan)
+	var progress *Progress
+	if opts.Progress > 0 || opts.ProgressLog != "" || (pusher != nil && opts.GraphiteProgress) {
+		var table io.Writer
+		if opts.Progress > 0 {
+			table = info
+		} else {
+			opts.Progress = 10 * time.Second
+		}
+		progress, err = NewProgress(opts.Progress, opts.ProgressRules, table, opts.ProgressLog)
+		if err != nil {
+			panic(err)
+		}
+		if pusher != nil && opts.GraphiteProgress {
+			pusher.StartProgress()
+			progress.Pusher = pusher
+		}
 	}
 
-	go resultAverage(resultsChan, doneChan)
+	var recorder *Recorder
+	if opts.RecordPath != "" {
+		recorder, err = NewRecorder(opts.RecordPath, profile.Start, opts.URL)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Fprintf(info, "Record:%s\n", opts.RecordPath)
+	}
 
-	for i := uint64(0); i < opts.ParallelCount; i++ {
+	summary := NewSummary(opts.MaxQueries)
+	go resultSummary(resultsChan, doneChan, summary, requestLog, recorder, slo, progress, metrics)
+
+	for i := 0; i < running; i++ {
 		<-doneChan
 	}
+	drainQueue(profile, workChan, resultsChan)
 	close(resultsChan)
 	<-doneChan
 
 	close(doneChan)
-	fmt.Println("All DONE!")
+
+	if requestLog != nil {
+		err = requestLog.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while writing request log: %s\n", err)
+		}
+	}
+	if recorder != nil {
+		err = recorder.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while writing record: %s\n", err)
+		}
+	}
+	if progress != nil {
+		err = progress.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while writing progress: %s\n", err)
+		}
+	}
+
+	err = WriteSummary(summary, opts, rules, opts.Output, opts.OutputFile)
+	if err != nil {
+		panic(err)
+	}
+	if pusher != nil {
+		err = pusher.PushSummary(summary)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while pushing results to Graphite: %s\n", err)
+		}
+	}
+
+	exitCode := ExitOK
+	if len(assertions) > 0 {
+		results, passed := CheckAssertions(assertions, summary)
+		fmt.Fprintln(info, "SLO report:")
+		PrintAssertions(info, results)
+		if !passed {
+			exitCode = ExitSLOFailed
+		}
+	}
+	if slo.Breached && slo.Abort {
+		exitCode = ExitSLOAborted
+	}
+	if count := summary.Total.Latency.Count(); count > 0 && summary.Total.Failed == count {
+		fmt.Fprintln(info, "All requests failed")
+		exitCode = ExitAllFailed
+	}
+	if atomic.LoadInt32(&interrupted) == 1 {
+		fmt.Fprintln(info, "Interrupted, results are partial")
+		exitCode = ExitInterrupted
+	}
+
+	fmt.Fprintln(info, "All DONE!")
+	os.Exit(exitCode)
 }
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ifireice/metric_reader/metric_reader/backend"
+	"github.com/ifireice/metric_reader/metric_reader/carbon"
+)
+
+// shutdown sends requests to server which replies after delay, cancels the run
+// once the first request is received and returns results of all requests
+func shutdown(delay time.Duration, drainTimeout time.Duration) ([]requestData, time.Duration) {
+	received := make(chan bool, 10)
+	release := make(chan bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- true
+		select {
+		case <-time.After(delay):
+		case <-release:
+		}
+		w.Write([]byte(`[{"target":"a","datapoints":[[1,1600000000]]}]`))
+	}))
+	defer server.Close()
+	defer close(release)
+
+	b := carbon.New(server.URL)
+	profile := NewLoadProfile(nil, 0, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	drainCtx, cancelDrain := newDrainContext(ctx, drainTimeout)
+	defer cancelDrain()
+
+	workChan := make(chan requestData, 3)
+	for i := 0; i < 3; i++ {
+		request := b.BuildRequest(backend.Query{Expr: "a"})
+		workChan <- requestData{Method: request.Method, URL: request.URL, Rule: "r"}
+	}
+	resultChan := make(chan requestData, 3)
+	doneChan := make(chan bool, 1)
+	go makeHTTPRequest(ctx, drainCtx, 0, profile, b, nil, workChan, resultChan, doneChan)
+
+	<-received
+	start := time.Now()
+	cancel()
+	// generator closes queue on stop
+	close(workChan)
+	<-doneChan
+	drainQueue(profile, workChan, resultChan)
+	stopped := time.Since(start)
+	close(resultChan)
+
+	results := make([]requestData, 0)
+	for request := range resultChan {
+		results = append(results, request)
+	}
+	return results, stopped
+}
+
+func TestShutdownDrain(t *testing.T) {
+	results, _ := shutdown(100*time.Millisecond, 5*time.Second)
+	if len(results) != 3 {
+		t.Fatalf("Not expected results: %+v", results)
+	}
+	// request in flight finishes, queued ones are canceled
+	if results[0].Status != backend.OK || results[0].Stage != "main" {
+		t.Errorf("Request in flight should finish: %+v", results[0])
+	}
+	for _, request := range results[1:] {
+		if request.Status != backend.Canceled || request.Failed || request.Stage != "main" || request.Scheduled.IsZero() {
+			t.Errorf("Queued request should be canceled: %+v", request)
+		}
+	}
+
+	summary := NewSummary(10)
+	for _, request := range results {
+		summary.Add(request)
+	}
+	if summary.Total.Latency.Count() != 1 || summary.Total.Canceled != 2 {
+		t.Errorf("Not expected summary: %d completed, %d canceled", summary.Total.Latency.Count(), summary.Total.Canceled)
+	}
+}
+
+func TestShutdownDrainTimeout(t *testing.T) {
+	results, stopped := shutdown(time.Minute, 50*time.Millisecond)
+	if stopped > time.Second {
+		t.Errorf("Request in flight is not aborted after drain timeout: %s", stopped)
+	}
+	if len(results) != 3 {
+		t.Fatalf("Not expected results: %+v", results)
+	}
+	for _, request := range results {
+		if request.Status != backend.Canceled {
+			t.Errorf("Request should be canceled: %+v", request)
+		}
+	}
+}
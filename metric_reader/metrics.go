@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// metricsBuckets are upper bounds of latency histogram buckets, seconds
+var metricsBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// droppedStatus is a status label of requests dropped by scheduler
+const droppedStatus = "dropped"
+
+type metricsKey struct {
+	rule   string
+	status string
+}
+
+type metricsSeries struct {
+	buckets []uint64
+	count   uint64
+	sum     float64
+	bytes   uint64
+}
+
+// Metrics is the tester's own stats in Prometheus text format.
+// Methods of nil Metrics do nothing, so it can be passed around when disabled
+type Metrics struct {
+	Backend  string
+	mu       sync.Mutex
+	series   map[metricsKey]*metricsSeries
+	inFlight int64
+	// queue returns number of requests waiting for worker
+	queue func() int
+}
+
+// NewMetrics returns empty metrics of backend, queue may be nil
+func NewMetrics(backendName string, queue func() int) *Metrics {
+	return &Metrics{
+		Backend: backendName,
+		series:  make(map[metricsKey]*metricsSeries),
+		queue:   queue,
+	}
+}
+
+// Started marks request as in flight
+func (m *Metrics) Started() {
+	if m == nil {
+		return
+	}
+	atomic.AddInt64(&m.inFlight, 1)
+}
+
+// Finished marks request as completed
+func (m *Metrics) Finished() {
+	if m == nil {
+		return
+	}
+	atomic.AddInt64(&m.inFlight, -1)
+}
+
+// Add records request result
+func (m *Metrics) Add(request requestData) {
+	if m == nil {
+		return
+	}
+	key := metricsKey{request.Rule, request.Status}
+	if request.Dropped {
+		key.status = droppedStatus
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.series[key]
+	if !ok {
+		s = &metricsSeries{buckets: make([]uint64, len(metricsBuckets))}
+		m.series[key] = s
+	}
+	s.count++
+	s.bytes += uint64(request.Bytes)
+	if request.Dropped {
+		return
+	}
+
+	seconds := request.Elapsed.Seconds()
+	s.sum += seconds
+	for i, le := range metricsBuckets {
+		if seconds <= le {
+			s.buckets[i]++
+		}
+	}
+}
+
+// labelValue escapes label value as required by text exposition format
+func labelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// Write writes metrics in Prometheus text exposition format
+func (m *Metrics) Write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keys := make([]metricsKey, 0, len(m.series))
+	for k := range m.series {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].rule != keys[j].rule {
+			return keys[i].rule < keys[j].rule
+		}
+		return keys[i].status < keys[j].status
+	})
+
+	labels := func(k metricsKey) string {
+		return fmt.Sprintf(`backend="%s",rule="%s",status="%s"`, labelValue(m.Backend), labelValue(k.rule), labelValue(k.status))
+	}
+
+	fmt.Fprintln(w, "# HELP metric_reader_requests_total Completed requests by outcome class, dropped requests have status \"dropped\".")
+	fmt.Fprintln(w, "# TYPE metric_reader_requests_total counter")
+	for _, k := range keys {
+		fmt.Fprintf(w, "metric_reader_requests_total{%s} %d\n", labels(k), m.series[k].count)
+	}
+
+	fmt.Fprintln(w, "# HELP metric_reader_response_bytes_total Received response body bytes.")
+	fmt.Fprintln(w, "# TYPE metric_reader_response_bytes_total counter")
+	for _, k := range keys {
+		fmt.Fprintf(w, "metric_reader_response_bytes_total{%s} %d\n", labels(k), m.series[k].bytes)
+	}
+
+	fmt.Fprintln(w, "# HELP metric_reader_request_duration_seconds Request latency from intended send time.")
+	fmt.Fprintln(w, "# TYPE metric_reader_request_duration_seconds histogram")
+	for _, k := range keys {
+		if k.status == droppedStatus {
+			continue
+		}
+		s := m.series[k]
+		for i, le := range metricsBuckets {
+			fmt.Fprintf(w, "metric_reader_request_duration_seconds_bucket{%s,le=\"%s\"} %d\n", labels(k), formatFloat(le), s.buckets[i])
+		}
+		fmt.Fprintf(w, "metric_reader_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels(k), s.count)
+		fmt.Fprintf(w, "metric_reader_request_duration_seconds_sum{%s} %s\n", labels(k), formatFloat(s.sum))
+		fmt.Fprintf(w, "metric_reader_request_duration_seconds_count{%s} %d\n", labels(k), s.count)
+	}
+
+	backendLabel := fmt.Sprintf(`backend="%s"`, labelValue(m.Backend))
+	fmt.Fprintln(w, "# HELP metric_reader_requests_in_flight Requests sent and waiting for response.")
+	fmt.Fprintln(w, "# TYPE metric_reader_requests_in_flight gauge")
+	fmt.Fprintf(w, "metric_reader_requests_in_flight{%s} %d\n", backendLabel, atomic.LoadInt64(&m.inFlight))
+
+	if m.queue != nil {
+		fmt.Fprintln(w, "# HELP metric_reader_queue_depth Generated requests waiting for worker.")
+		fmt.Fprintln(w, "# TYPE metric_reader_queue_depth gauge")
+		fmt.Fprintf(w, "metric_reader_queue_depth{%s} %d\n", backendLabel, m.queue())
+	}
+}
+
+// Listen serves metrics on addr /metrics in background
+func (m *Metrics) Listen(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		m.Write(w)
+	})
+	go func() {
+		err := http.Serve(listener, mux)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while serving metrics: %s\n", err)
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsWrite(t *testing.T) {
+	m := NewMetrics("Carbon", func() int { return 7 })
+	m.Started()
+	m.Started()
+	m.Finished()
+	m.Add(requestData{Rule: `a"b`, Status: "ok", Elapsed: 20 * time.Millisecond, Bytes: 10})
+	m.Add(requestData{Rule: `a"b`, Status: "ok", Elapsed: 2 * time.Second, Bytes: 5})
+	m.Add(requestData{Rule: `a"b`, Dropped: true})
+
+	var buf bytes.Buffer
+	m.Write(&buf)
+	out := buf.String()
+
+	for _, line := range []string{
+		`metric_reader_requests_total{backend="Carbon",rule="a\"b",status="ok"} 2`,
+		`metric_reader_requests_total{backend="Carbon",rule="a\"b",status="dropped"} 1`,
+		`metric_reader_response_bytes_total{backend="Carbon",rule="a\"b",status="ok"} 15`,
+		`metric_reader_request_duration_seconds_bucket{backend="Carbon",rule="a\"b",status="ok",le="0.025"} 1`,
+		`metric_reader_request_duration_seconds_bucket{backend="Carbon",rule="a\"b",status="ok",le="2.5"} 2`,
+		`metric_reader_request_duration_seconds_bucket{backend="Carbon",rule="a\"b",status="ok",le="+Inf"} 2`,
+		`metric_reader_request_duration_seconds_sum{backend="Carbon",rule="a\"b",status="ok"} 2.02`,
+		`metric_reader_requests_in_flight{backend="Carbon"} 1`,
+		`metric_reader_queue_depth{backend="Carbon"} 7`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("Line '%s' not found in:\n%s", line, out)
+		}
+	}
+	if strings.Contains(out, `status="dropped",le=`) {
+		t.Error("Dropped requests should not be in latency histogram")
+	}
+
+	// disabled metrics
+	var nilMetrics *Metrics
+	nilMetrics.Started()
+	nilMetrics.Add(requestData{})
+}
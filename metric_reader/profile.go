@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// warmupStage is a stage name excluded from stats
+const warmupStage = "warmup"
+
+// Stage is a part of the run with fixed load level.
+// Level is a number of workers in closed-loop mode or requests per second with -rate
+type Stage struct {
+	Name     string
+	Duration time.Duration
+	Level    float64
+}
+
+// ParseStages parses stages string like "2m:10,2m:50,2m:100"
+func ParseStages(stagesStr string) ([]Stage, error) {
+	ret := make([]Stage, 0)
+	if stagesStr == "" {
+		return ret, nil
+	}
+
+	for i, s := range strings.Split(stagesStr, ",") {
+		parts := strings.Split(strings.TrimSpace(s), ":")
+		if len(parts) != 2 {
+			return ret, fmt.Errorf("Cant parse stage: '%s'", s)
+		}
+		duration, err := time.ParseDuration(parts[0])
+		if err != nil {
+			return ret, err
+		}
+		level, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil {
+			return ret, err
+		}
+		if duration <= 0 || level <= 0 {
+			return ret, fmt.Errorf("Stage duration and level should be positive: '%s'", s)
+		}
+		ret = append(ret, Stage{fmt.Sprintf("stage%02d-%g", i+1, level), duration, level})
+	}
+	return ret, nil
+}
+
+// LoadProfile is a sequence of stages starting at Start.
+// The last stage lasts until the end of the run
+type LoadProfile struct {
+	Start  time.Time
+	Stages []Stage
+	// LimitWorkers is true if stage level limits number of active workers
+	LimitWorkers bool
+}
+
+// NewLoadProfile returns profile with optional warm-up stage before stages.
+// Without stages the whole run is a single stage with defaultLevel
+func NewLoadProfile(stages []Stage, warmup time.Duration, defaultLevel float64) *LoadProfile {
+	if len(stages) == 0 {
+		stages = []Stage{{"main", 0, defaultLevel}}
+	}
+
+	profile := &LoadProfile{Start: time.Now()}
+	if warmup > 0 {
+		profile.Stages = append(profile.Stages, Stage{warmupStage, warmup, stages[0].Level})
+	}
+	profile.Stages = append(profile.Stages, stages...)
+	return profile
+}
+
+func (p *LoadProfile) stageIndex(t time.Time) int {
+	elapsed := t.Sub(p.Start)
+	for i, s := range p.Stages {
+		if elapsed < s.Duration {
+			return i
+		}
+		elapsed -= s.Duration
+	}
+	return len(p.Stages) - 1
+}
+
+// At returns stage active at time t
+func (p *LoadProfile) At(t time.Time) Stage {
+	return p.Stages[p.stageIndex(t)]
+}
+
+// Total returns sum of all stages durations
+func (p *LoadProfile) Total() time.Duration {
+	total := time.Duration(0)
+	for _, s := range p.Stages {
+		total += s.Duration
+	}
+	return total
+}
+
+// MaxLevel returns max level over all stages
+func (p *LoadProfile) MaxLevel() float64 {
+	max := float64(0)
+	for _, s := range p.Stages {
+		if s.Level > max {
+			max = s.Level
+		}
+	}
+	return max
+}
+
+// WaitActive blocks until worker with given index is active in current stage.
+// Returns false if worker will never be active again or ctx is done
+func (p *LoadProfile) WaitActive(ctx context.Context, worker int) bool {
+	if !p.LimitWorkers {
+		return ctx.Err() == nil
+	}
+	for {
+		if ctx.Err() != nil {
+			return false
+		}
+		i := p.stageIndex(time.Now())
+		if float64(worker) < p.Stages[i].Level {
+			return true
+		}
+		if i == len(p.Stages)-1 {
+			return false
+		}
+		select {
+		case <-time.After(100 * time.Millisecond):
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestParseStages(t *testing.T) {
+	stages, err := ParseStages("2m:10, 2m:50,1m30s:100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stages) != 3 {
+		t.Fatalf("Not expected stages count: %d", len(stages))
+	}
+	if stages[2].Duration != 90*time.Second || stages[2].Level != 100 {
+		t.Errorf("Not expected stage: %v", stages[2])
+	}
+
+	for _, bad := range []string{"2m", "2m:x", "x:10", "2m:0", "2m:10,"} {
+		if _, err := ParseStages(bad); err == nil {
+			t.Errorf("Error should not be nil for stages '%s'", bad)
+		}
+	}
+}
+
+func TestLoadProfileAt(t *testing.T) {
+	stages, _ := ParseStages("1m:10,1m:20")
+	profile := NewLoadProfile(stages, 30*time.Second, 1)
+	profile.LimitWorkers = true
+
+	check := func(offset time.Duration, name string) {
+		if got := profile.At(profile.Start.Add(offset)).Name; got != name {
+			t.Errorf("At(%s): %s != %s", offset, got, name)
+		}
+	}
+	check(0, warmupStage)
+	check(45*time.Second, "stage01-10")
+	check(2*time.Minute, "stage02-20")
+	check(time.Hour, "stage02-20")
+
+	if profile.Total() != 150*time.Second {
+		t.Errorf("Total: %s != 2m30s", profile.Total())
+	}
+	if profile.MaxLevel() != 20 {
+		t.Errorf("MaxLevel: %v != 20", profile.MaxLevel())
+	}
+}
+
+func TestWaitActiveCanceled(t *testing.T) {
+	stages, _ := ParseStages("1m:1,1m:10")
+	profile := NewLoadProfile(stages, 0, 1)
+	profile.LimitWorkers = true
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if !profile.WaitActive(ctx, 0) {
+		t.Error("Worker 0 should be active")
+	}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+	if profile.WaitActive(ctx, 5) {
+		t.Error("Worker 5 should not be active after cancel")
+	}
+	if profile.WaitActive(ctx, 0) {
+		t.Error("Worker 0 should not be active after cancel")
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Progress reports stats of the last interval during the run,
+// as a human readable table and/or NDJSON stream
+type Progress struct {
+	Interval time.Duration
+	PerRule  bool
+	start    time.Time
+	last     time.Time
+	stage    string
+	window   *Stats
+	rules    map[string]*Stats
+	table    io.Writer
+	header   bool
+	file     *os.File
+	encoder  *json.Encoder
+	// Pusher sends interval stats to Graphite in background if set, it should be started
+	Pusher *GraphitePusher
+}
+
+// ProgressEntry is a NDJSON record of interval stats, durations are in milliseconds
+type ProgressEntry struct {
+	Time      time.Time `json:"time"`
+	ElapsedS  float64   `json:"elapsed_s"`
+	IntervalS float64   `json:"interval_s"`
+	Stage     string    `json:"stage"`
+	Rule      string    `json:"rule,omitempty"`
+	Count     uint64    `json:"count"`
+	Failed    uint64    `json:"failed"`
+	Late      uint64    `json:"late"`
+	Dropped   uint64    `json:"dropped"`
+	RPS       float64   `json:"rps"`
+	ErrorRate float64   `json:"error_rate"`
+	P50Ms     float64   `json:"p50_ms"`
+	P99Ms     float64   `json:"p99_ms"`
+}
+
+// NewProgress returns progress reporter writing table to w (nil disables table)
+// and NDJSON stream to streamPath (empty disables stream)
+func NewProgress(interval time.Duration, perRule bool, w io.Writer, streamPath string) (*Progress, error) {
+	now := time.Now()
+	p := &Progress{
+		Interval: interval,
+		PerRule:  perRule,
+		start:    now,
+		last:     now,
+		window:   NewStats(),
+		rules:    make(map[string]*Stats),
+		table:    w,
+	}
+	if streamPath != "" {
+		file, err := os.Create(streamPath)
+		if err != nil {
+			return nil, err
+		}
+		// not buffered, so the stream can be tailed
+		p.file = file
+		p.encoder = json.NewEncoder(file)
+	}
+	return p, nil
+}
+
+// Add records request result in the current interval
+func (p *Progress) Add(request requestData) {
+	p.stage = request.Stage
+	p.window.Add(request)
+	if p.PerRule {
+		getStats(p.rules, request.Rule, nil).Add(request)
+	}
+}
+
+func (p *Progress) entry(now time.Time, interval time.Duration, rule string, s *Stats) ProgressEntry {
+	count := s.Latency.Count()
+	return ProgressEntry{
+		Time:      now,
+		ElapsedS:  now.Sub(p.start).Seconds(),
+		IntervalS: interval.Seconds(),
+		Stage:     p.stage,
+		Rule:      rule,
+		Count:     count,
+		Failed:    s.Failed,
+		Late:      s.Late,
+		Dropped:   s.Dropped,
+		RPS:       float64(count) / interval.Seconds(),
+		ErrorRate: fraction(s.Failed, count),
+		P50Ms:     toMs(s.Latency.Quantile(0.5)),
+		P99Ms:     toMs(s.Latency.Quantile(0.99)),
+	}
+}
+
+func (p *Progress) printEntry(e ProgressEntry) {
+	if !p.header {
+		fmt.Fprintf(p.table, "%9s %-12s %8s %8s %8s %8s %8s %9s %10s %10s  %s\n",
+			"elapsed", "stage", "count", "failed", "errors", "late", "dropped", "rps", "p50", "p99", "rule")
+		p.header = true
+	}
+	fmt.Fprintf(p.table, "%9s %-12s %8d %8d %7.2f%% %8d %8d %9.2f %10s %10s  %s\n",
+		time.Duration(e.ElapsedS*float64(time.Second)).Round(time.Second), e.Stage,
+		e.Count, e.Failed, e.ErrorRate*100, e.Late, e.Dropped, e.RPS,
+		fmtDuration(fromMs(e.P50Ms)), fmtDuration(fromMs(e.P99Ms)), e.Rule)
+}
+
+// Report writes stats of the interval since the previous report and starts a new interval
+func (p *Progress) Report(now time.Time) error {
+	interval := now.Sub(p.last)
+	if interval <= 0 {
+		return nil
+	}
+
+	entries := []ProgressEntry{p.entry(now, interval, "", p.window)}
+	for _, rule := range sortedKeys(p.rules) {
+		entries = append(entries, p.entry(now, interval, rule, p.rules[rule]))
+	}
+
+	p.last = now
+	p.window = NewStats()
+	p.rules = make(map[string]*Stats)
+
+	for _, e := range entries {
+		if p.table != nil {
+			p.printEntry(e)
+		}
+		if p.encoder != nil {
+			err := p.encoder.Encode(e)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if p.Pusher != nil {
+		p.Pusher.QueueProgress(entries)
+	}
+	return nil
+}
+
+// Close waits for queued pushes and closes NDJSON stream
+func (p *Progress) Close() error {
+	if p.Pusher != nil {
+		p.Pusher.StopProgress()
+	}
+	if p.file == nil {
+		return nil
+	}
+	return p.file.Close()
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProgressReport(t *testing.T) {
+	var table bytes.Buffer
+	stream := filepath.Join(t.TempDir(), "progress.ndjson")
+	p, err := NewProgress(time.Second, true, &table, stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := p.last
+	p.Add(requestData{Rule: "a", Stage: "main", Elapsed: 10 * time.Millisecond})
+	p.Add(requestData{Rule: "b", Stage: "main", Elapsed: 30 * time.Millisecond, Failed: true})
+	if err := p.Report(now.Add(2 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	// next interval is empty
+	if err := p.Report(now.Add(4 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("Not expected stream lines count: %d", len(lines))
+	}
+
+	var total ProgressEntry
+	if err := json.Unmarshal([]byte(lines[0]), &total); err != nil {
+		t.Fatal(err)
+	}
+	if total.Rule != "" || total.Count != 2 || total.RPS != 1 || total.ErrorRate != 0.5 {
+		t.Errorf("Not expected total entry: %+v", total)
+	}
+
+	var last ProgressEntry
+	if err := json.Unmarshal([]byte(lines[3]), &last); err != nil {
+		t.Fatal(err)
+	}
+	if last.Count != 0 || last.IntervalS != 2 {
+		t.Errorf("Window is not reset: %+v", last)
+	}
+
+	if strings.Count(table.String(), "elapsed") != 1 {
+		t.Errorf("Table header should be printed once:\n%s", table.String())
+	}
+}
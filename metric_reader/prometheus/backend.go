@@ -0,0 +1,138 @@
+package prometheus
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/ifireice/metric_reader/metric_reader/backend"
+)
+
+// Name is a backend name for -source
+const Name = "Prometheus"
+
+func init() {
+	backend.Register(Name, New)
+}
+
+// Backend is a prometheus HTTP API backend
+type Backend struct {
+	URL string
+}
+
+// New returns prometheus backend for base URL
+func New(baseURL string) backend.Backend {
+	return &Backend{URL: baseURL}
+}
+
+// Name returns backend name
+func (b *Backend) Name() string {
+	return Name
+}
+
+// RandomSeries returns label matcher of random series
+func (b *Backend) RandomSeries() (string, error) {
+	return GetRandomTags(b.URL)
+}
+
+// RandomTagValue returns random value of label
+func (b *Backend) RandomTagValue(tag string) (string, error) {
+	values, err := getLabelValues(b.URL, tag)
+	if err != nil {
+		return "", err
+	}
+	if len(values) == 0 {
+		return "", fmt.Errorf("No values found for label %s", tag)
+	}
+	return getRandom(values), nil
+}
+
+// TagMatcher returns label matcher: label="value"
+func (b *Backend) TagMatcher(tag string, value string) string {
+	return fmt.Sprintf("%s=%s", tag, strconv.Quote(value))
+}
+
+var matcherRe = regexp.MustCompile(`([a-zA-Z_][a-zA-Z0-9_]*)\s*=\s*("(?:[^"\\]|\\.)*")`)
+
+// ParseSeries returns labels of selector like {job="x",instance="y"}
+func (b *Backend) ParseSeries(series string) map[string]string {
+	labels := make(map[string]string)
+	for _, m := range matcherRe.FindAllStringSubmatch(series, -1) {
+		value, err := strconv.Unquote(m[2])
+		if err == nil {
+			labels[m[1]] = value
+		}
+	}
+	return labels
+}
+
+// Discover returns label matchers of all series
+func (b *Backend) Discover(concurrency int) ([]string, error) {
+	return DiscoverSeries(b.URL, concurrency)
+}
+
+// BuildRequest returns instant or range query request, only JSON format is supported
+func (b *Backend) BuildRequest(query backend.Query) backend.Request {
+	queryURL := GetURL(b.URL, query.Expr, query.From, query.Until)
+	if query.Range {
+		queryURL = GetRangeURL(b.URL, query.Expr, query.From, query.Until, b.Interval(query))
+	}
+	return backend.Request{Method: http.MethodGet, URL: queryURL}
+}
+
+// DefaultMaxDataPoints is a number of points of range query without MaxDataPoints, as Grafana panel width
+const DefaultMaxDataPoints = 1000
+
+// Interval returns query step, computed from MaxDataPoints if not set
+func (b *Backend) Interval(query backend.Query) time.Duration {
+	if query.Step > 0 {
+		return query.Step
+	}
+	maxDataPoints := query.MaxDataPoints
+	if maxDataPoints <= 0 {
+		maxDataPoints = DefaultMaxDataPoints
+	}
+	return Step(query.From, query.Until, maxDataPoints)
+}
+
+// Validate checks API reply status and result.
+// Errors reported by prometheus in JSON reply are backend errors regardless of HTTP status
+func (b *Backend) Validate(query backend.Query, statusCode int, body []byte) (string, error) {
+	var reply apiReply
+	err := json.Unmarshal(body, &reply)
+	if err != nil {
+		if statusCode < 200 || statusCode > 299 {
+			return backend.HTTPStatusClass(statusCode), fmt.Errorf("HTTP status %d: %.100s", statusCode, body)
+		}
+		return backend.ParseError, err
+	}
+
+	if reply.Status != "success" {
+		return backend.BackendError, fmt.Errorf("Prometheus API error %s: %s", reply.ErrorType, reply.Error)
+	}
+
+	var data struct {
+		ResultType string          `json:"resultType"`
+		Result     json.RawMessage `json:"result"`
+	}
+	err = json.Unmarshal(reply.Data, &data)
+	if err != nil {
+		return backend.ParseError, err
+	}
+
+	// scalar and string results are arrays [time, value]
+	if data.ResultType == "matrix" || data.ResultType == "vector" {
+		var result []json.RawMessage
+		err = json.Unmarshal(data.Result, &result)
+		if err != nil {
+			return backend.ParseError, err
+		}
+		if len(result) == 0 {
+			return backend.EmptyResult, nil
+		}
+	}
+	return backend.OK, nil
+}
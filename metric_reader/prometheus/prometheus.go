@@ -1,47 +1,248 @@
 package prometheus
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"math"
+	"math/rand"
 	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
 	"time"
+
+	"github.com/ifireice/metric_reader/metric_reader/backend"
 )
 
-// GetURL generates full URL for prometheus API
-func GetURL(url string, metricName string, from time.Time, until time.Time) string {
-	return fmt.Sprintf("%s/api/v1/query?query=%s", url, metricName)
+// GetURL generates full URL for prometheus instant query evaluated at until
+func GetURL(baseURL string, metricName string, from time.Time, until time.Time) string {
+	return fmt.Sprintf("%s/api/v1/query?query=%s&time=%d", baseURL, url.QueryEscape(metricName), until.Unix())
 }
 
-// GetAllTagsValues returns all targets with tags
-func GetAllTagsValues(carbonURL string) (map[string][]string, error) {
-	return make(map[string][]string, 0), fmt.Errorf("Not implemeted")
+// GetRangeURL generates full URL for prometheus range query
+func GetRangeURL(baseURL string, metricName string, from time.Time, until time.Time, step time.Duration) string {
+	return fmt.Sprintf("%s/api/v1/query_range?query=%s&start=%d&end=%d&step=%g",
+		baseURL, url.QueryEscape(metricName), from.Unix(), until.Unix(), step.Seconds())
 }
 
-// GetAllMetrics retuens all metric names from prometheus
-func GetAllMetrics(promURL string) ([]string, error) {
-	type Metrics struct {
-		Status string   `json:"status"`
-		Data   []string `json:"data"`
+// Step returns range query step like Grafana does:
+// range divided by maxDataPoints, rounded to a nice interval, not less than 1s
+// and not producing more than 11000 points (Prometheus limit)
+func Step(from time.Time, until time.Time, maxDataPoints int) time.Duration {
+	period := until.Sub(from)
+	if maxDataPoints <= 0 {
+		maxDataPoints = 1
 	}
+	step := roundInterval(period / time.Duration(maxDataPoints))
 
-	url := fmt.Sprintf("%s/api/v1/label/__name__/values", promURL)
-	resp, err := http.Get(url)
-	if err != nil {
-		return make([]string, 0), err
+	safeStep := time.Duration(math.Ceil(period.Seconds()/11000)) * time.Second
+	if step < safeStep {
+		step = safeStep
+	}
+	if step < time.Second {
+		step = time.Second
+	}
+	return step
+}
+
+// roundInterval rounds interval to a nice value, as Grafana kbn.roundInterval
+func roundInterval(interval time.Duration) time.Duration {
+	bounds := []struct {
+		limit time.Duration
+		value time.Duration
+	}{
+		{1500 * time.Millisecond, time.Second},
+		{3500 * time.Millisecond, 2 * time.Second},
+		{7500 * time.Millisecond, 5 * time.Second},
+		{12500 * time.Millisecond, 10 * time.Second},
+		{17500 * time.Millisecond, 15 * time.Second},
+		{25 * time.Second, 20 * time.Second},
+		{45 * time.Second, 30 * time.Second},
+		{90 * time.Second, time.Minute},
+		{210 * time.Second, 2 * time.Minute},
+		{450 * time.Second, 5 * time.Minute},
+		{750 * time.Second, 10 * time.Minute},
+		{1050 * time.Second, 15 * time.Minute},
+		{1500 * time.Second, 20 * time.Minute},
+		{2700 * time.Second, 30 * time.Minute},
+		{5400 * time.Second, time.Hour},
+		{9000 * time.Second, 2 * time.Hour},
+		{16200 * time.Second, 3 * time.Hour},
+		{32400 * time.Second, 6 * time.Hour},
+		{86400 * time.Second, 12 * time.Hour},
+		{604800 * time.Second, 24 * time.Hour},
+		{1814400 * time.Second, 7 * 24 * time.Hour},
+		{3628800 * time.Second, 30 * 24 * time.Hour},
 	}
 
+	for _, b := range bounds {
+		if interval < b.limit {
+			return b.value
+		}
+	}
+	return 365 * 24 * time.Hour
+}
+
+// apiReply is a common prometheus API reply
+type apiReply struct {
+	Status    string          `json:"status"`
+	Data      json.RawMessage `json:"data"`
+	ErrorType string          `json:"errorType"`
+	Error     string          `json:"error"`
+}
+
+// getAPI requests prometheus API and unmarshals data of the reply
+func getAPI(apiURL string, data interface{}) error {
+	client := http.Client{
+		Timeout: time.Duration(30 * time.Second),
+	}
+
+	resp, err := client.Get(apiURL)
+	if err != nil {
+		return err
+	}
 	defer resp.Body.Close()
+
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return make([]string, 0), err
+		return err
 	}
 
-	var reply Metrics
+	var reply apiReply
 	err = json.Unmarshal(body, &reply)
+	if err != nil {
+		return err
+	}
+	if reply.Status != "success" {
+		return fmt.Errorf("Prometheus API error %s: %s", reply.ErrorType, reply.Error)
+	}
+
+	return json.Unmarshal(reply.Data, data)
+}
+
+func getLabelNames(promURL string) ([]string, error) {
+	var names []string
+	err := getAPI(fmt.Sprintf("%s/api/v1/labels", promURL), &names)
+	return names, err
+}
+
+func getLabelValues(promURL string, name string) ([]string, error) {
+	var values []string
+	err := getAPI(fmt.Sprintf("%s/api/v1/label/%s/values", promURL, url.PathEscape(name)), &values)
+	return values, err
+}
+
+// getSeries returns label sets of series matching selector during last hour
+func getSeries(promURL string, selector string) ([]map[string]string, error) {
+	var series []map[string]string
+	now := time.Now()
+	seriesURL := fmt.Sprintf("%s/api/v1/series?match[]=%s&start=%d&end=%d",
+		promURL, url.QueryEscape(selector), now.Add(-time.Hour).Unix(), now.Unix())
+	err := getAPI(seriesURL, &series)
+	return series, err
+}
+
+// Matcher formats label set as PromQL selector: {job="x",instance="y"}
+func Matcher(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	ret := bytes.NewBuffer([]byte("{"))
+	for i, name := range names {
+		if i > 0 {
+			ret.WriteString(",")
+		}
+		ret.WriteString(fmt.Sprintf("%s=%s", name, strconv.Quote(labels[name])))
+	}
+	ret.WriteString("}")
+	return ret.String()
+}
+
+// GetAllTagsValues returns map with all label names and values
+func GetAllTagsValues(promURL string) (map[string][]string, error) {
+	metrics := make(map[string][]string, 0)
+	names, err := getLabelNames(promURL)
+	if err != nil {
+		return metrics, err
+	}
+
+	for _, name := range names {
+		values, err := getLabelValues(promURL, name)
+		if err != nil {
+			return metrics, err
+		}
+		metrics[name] = values
+	}
+	return metrics, nil
+}
+
+// DiscoverSeries returns label matchers of all series active during last hour.
+// Series are requested per metric name with at most concurrency requests at once
+func DiscoverSeries(promURL string, concurrency int) ([]string, error) {
+	names, err := GetAllMetrics(promURL)
 	if err != nil {
 		return make([]string, 0), err
 	}
 
-	return reply.Data, nil
+	ret, err := backend.Crawl(names, concurrency, func(name string) ([]string, error) {
+		series, err := getSeries(promURL, Matcher(map[string]string{"__name__": name}))
+		matchers := make([]string, 0, len(series))
+		for _, labels := range series {
+			matchers = append(matchers, Matcher(labels))
+		}
+		return matchers, err
+	})
+	sort.Strings(ret)
+	return ret, err
+}
+
+func getRandom(a []string) string {
+	n := rand.Int63n(int64(len(a)))
+	return a[n]
+}
+
+// GetRandomTags returns label matcher of random series.
+// Series is picked by random label name and value
+func GetRandomTags(promURL string) (string, error) {
+	names, err := getLabelNames(promURL)
+	if err != nil {
+		return "", err
+	}
+	if len(names) == 0 {
+		return "", fmt.Errorf("No labels found")
+	}
+	name := getRandom(names)
+
+	values, err := getLabelValues(promURL, name)
+	if err != nil {
+		return "", err
+	}
+	if len(values) == 0 {
+		return "", fmt.Errorf("No values found for label %s", name)
+	}
+	value := getRandom(values)
+
+	series, err := getSeries(promURL, Matcher(map[string]string{name: value}))
+	if err != nil {
+		return "", err
+	}
+	if len(series) == 0 {
+		return "", fmt.Errorf("No series found for %s=%s", name, value)
+	}
+
+	return Matcher(series[rand.Intn(len(series))]), nil
+}
+
+// GetAllMetrics retuens all metric names from prometheus
+func GetAllMetrics(promURL string) ([]string, error) {
+	names, err := getLabelValues(promURL, "__name__")
+	if err != nil {
+		return make([]string, 0), err
+	}
+	return names, nil
 }
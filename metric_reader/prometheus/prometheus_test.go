@@ -0,0 +1,186 @@
+package prometheus
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ifireice/metric_reader/metric_reader/backend"
+)
+
+func TestStep(t *testing.T) {
+	until := time.Unix(1600000000, 0)
+	check := func(period time.Duration, maxDataPoints int, expected time.Duration) {
+		if step := Step(until.Add(-period), until, maxDataPoints); step != expected {
+			t.Errorf("Step(%s, %d): %s != %s", period, maxDataPoints, step, expected)
+		}
+	}
+	check(time.Minute, 1000, time.Second)
+	check(time.Hour, 1000, 5*time.Second)
+	check(24*time.Hour, 1000, time.Minute)
+	check(7*24*time.Hour, 1000, 10*time.Minute)
+	check(time.Hour, 10, 5*time.Minute)
+	check(30*24*time.Hour, 100000, 236*time.Second)
+}
+
+func TestInterval(t *testing.T) {
+	until := time.Unix(1600000000, 0)
+	b := New("")
+	query := backend.Query{From: until.Add(-time.Hour), Until: until, MaxDataPoints: 1000}
+	if interval := b.Interval(query); interval != 5*time.Second {
+		t.Errorf("Not expected interval: %s", interval)
+	}
+	query.MaxDataPoints = 0
+	if interval := b.Interval(query); interval != 5*time.Second {
+		t.Errorf("Not expected default interval: %s", interval)
+	}
+	query.Step = time.Minute
+	if interval := b.Interval(query); interval != time.Minute {
+		t.Errorf("Not expected interval of step: %s", interval)
+	}
+}
+
+func TestMatcher(t *testing.T) {
+	labels := map[string]string{"job": "x", "instance": "y:9090", "__name__": "up", "path": `a"b`}
+	expected := `{__name__="up",instance="y:9090",job="x",path="a\"b"}`
+	if m := Matcher(labels); m != expected {
+		t.Errorf("Matcher: %s != %s", m, expected)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	b := New("http://localhost")
+	check := func(statusCode int, body string, expected string) {
+		class, _ := b.Validate(backend.Query{}, statusCode, []byte(body))
+		if class != expected {
+			t.Errorf("Validate(%d, '%s'): %s != %s", statusCode, body, class, expected)
+		}
+	}
+	check(200, `{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1,"1"]}]}}`, backend.OK)
+	check(200, `{"status":"success","data":{"resultType":"matrix","result":[]}}`, backend.EmptyResult)
+	check(200, `{"status":"success","data":{"resultType":"scalar","result":[1,"1"]}}`, backend.OK)
+	check(422, `{"status":"error","errorType":"execution","error":"too many samples"}`, backend.BackendError)
+	check(200, `{"status":`, backend.ParseError)
+	check(502, `Bad Gateway`, "http_5xx")
+}
+
+func TestParseSeries(t *testing.T) {
+	b := New("")
+	labels := b.ParseSeries(Matcher(map[string]string{"job": `a"b`, "__name__": "up"}))
+	if len(labels) != 2 || labels["job"] != `a"b` || labels["__name__"] != "up" {
+		t.Errorf("Not expected labels: %v", labels)
+	}
+	if m := b.TagMatcher("job", "node"); m != `job="node"` {
+		t.Errorf("Not expected matcher: %s", m)
+	}
+}
+
+// apiServer serves discovery API of series, requests of path prefix fail get error reply
+func apiServer(series []map[string]string, fail string) *httptest.Server {
+	matcherRe := regexp.MustCompile(`(\w+)="([^"]*)"`)
+	values := func(name string) []string {
+		set := make(map[string]bool)
+		for _, s := range series {
+			if v, ok := s[name]; ok {
+				set[v] = true
+			}
+		}
+		ret := make([]string, 0)
+		for v := range set {
+			ret = append(ret, v)
+		}
+		sort.Strings(ret)
+		return ret
+	}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reply := map[string]interface{}{"status": "success"}
+		switch {
+		case fail != "" && strings.HasPrefix(r.URL.Path, fail):
+			reply = map[string]interface{}{"status": "error", "errorType": "internal", "error": "failed"}
+		case r.URL.Path == "/api/v1/labels":
+			set := make(map[string]bool)
+			for _, s := range series {
+				for name := range s {
+					set[name] = true
+				}
+			}
+			names := make([]string, 0)
+			for name := range set {
+				names = append(names, name)
+			}
+			reply["data"] = names
+		case strings.HasPrefix(r.URL.Path, "/api/v1/label/"):
+			reply["data"] = values(strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/v1/label/"), "/values"))
+		case r.URL.Path == "/api/v1/series":
+			matched := make([]map[string]string, 0)
+			for _, s := range series {
+				ok := true
+				for _, m := range matcherRe.FindAllStringSubmatch(r.URL.Query().Get("match[]"), -1) {
+					ok = ok && s[m[1]] == m[2]
+				}
+				if ok {
+					matched = append(matched, s)
+				}
+			}
+			reply["data"] = matched
+		}
+		json.NewEncoder(w).Encode(reply)
+	}))
+}
+
+func TestDiscoverSeries(t *testing.T) {
+	series := []map[string]string{
+		{"__name__": "up", "job": "a"},
+		{"__name__": "up", "job": "b"},
+		{"__name__": "rate", "job": "a"},
+	}
+	server := apiServer(series, "")
+	defer server.Close()
+
+	found, err := DiscoverSeries(server.URL, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{`{__name__="rate",job="a"}`, `{__name__="up",job="a"}`, `{__name__="up",job="b"}`}
+	if !reflect.DeepEqual(found, expected) {
+		t.Errorf("Not expected series: %v", found)
+	}
+
+	matcher, err := GetRandomTags(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if matcher != expected[0] && matcher != expected[1] && matcher != expected[2] {
+		t.Errorf("Not expected random series: %s", matcher)
+	}
+}
+
+func TestDiscoverSeriesErrors(t *testing.T) {
+	series := []map[string]string{{"__name__": "up", "job": "a"}}
+	for _, fail := range []string{"/api/v1/label/", "/api/v1/series"} {
+		server := apiServer(series, fail)
+		if _, err := DiscoverSeries(server.URL, 1); err == nil {
+			t.Errorf("DiscoverSeries: error should not be nil for failed %s", fail)
+		}
+		if _, err := GetRandomTags(server.URL); err == nil {
+			t.Errorf("GetRandomTags: error should not be nil for failed %s", fail)
+		}
+		server.Close()
+	}
+
+	server := apiServer(nil, "")
+	defer server.Close()
+	if found, err := DiscoverSeries(server.URL, 1); err != nil || len(found) != 0 {
+		t.Errorf("Not expected series of empty server: %v, %v", found, err)
+	}
+	if _, err := GetRandomTags(server.URL); err == nil || err.Error() != "No labels found" {
+		t.Errorf("Not expected error of empty server: %v", err)
+	}
+}
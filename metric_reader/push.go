@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/ifireice/metric_reader/graphite"
+)
+
+// totalRule is a rule tag value of overall stats
+const totalRule = "total"
+
+// progressQueue is a max number of interval pushes waiting to be sent, later ones are dropped
+const progressQueue = 4
+
+// GraphitePusher sends run stats to carbon as tagged plaintext metrics, e.g.
+// 'metric_reader.summary.latency_ms;percentile=p99;rule=total;run_id=nightly;source=Carbon 12.5 1600000000'
+type GraphitePusher struct {
+	Addr   string
+	Prefix string
+	RunID  string
+	Source string
+	queue  chan []ProgressEntry
+	done   chan bool
+}
+
+// NewGraphitePusher returns pusher to carbon plaintext receiver addr
+func NewGraphitePusher(addr string, prefix string, runID string, source string) *GraphitePusher {
+	return &GraphitePusher{Addr: addr, Prefix: prefix, RunID: runID, Source: source}
+}
+
+// CheckPushOptions checks that interval stats have carbon address to be pushed to
+func CheckPushOptions(addr string, progress bool) error {
+	if progress && addr == "" {
+		return fmt.Errorf("Interval stats are pushed to -graphite_addr, it is not set")
+	}
+	return nil
+}
+
+func (g *GraphitePusher) metric(name string, value float64, ts time.Time, tags map[string]string) graphite.Metric {
+	all := map[string]string{"run_id": g.RunID, "source": g.Source}
+	for k, v := range tags {
+		all[k] = v
+	}
+	return graphite.Metric{
+		Timestamp:   ts.Unix(),
+		MetricName:  g.Prefix + "." + name,
+		MetricValue: value,
+		Tags:        all,
+	}
+}
+
+// statsMetrics returns metrics of stats group under prefix.group
+func (g *GraphitePusher) statsMetrics(group string, rule string, ts time.Time, s StatsReport) []graphite.Metric {
+	tags := map[string]string{"rule": rule}
+	percentile := func(p string) map[string]string {
+		return map[string]string{"rule": rule, "percentile": p}
+	}
+	errorRate := fraction(s.Failed, s.Count)
+
+	return []graphite.Metric{
+		g.metric(group+".count", float64(s.Count), ts, tags),
+		g.metric(group+".failed", float64(s.Failed), ts, tags),
+		g.metric(group+".late", float64(s.Late), ts, tags),
+		g.metric(group+".dropped", float64(s.Dropped), ts, tags),
+		g.metric(group+".bytes", float64(s.Bytes), ts, tags),
+		g.metric(group+".rps", s.RPS, ts, tags),
+		g.metric(group+".error_rate", errorRate, ts, tags),
+		g.metric(group+".latency_ms", s.P50Ms, ts, percentile("p50")),
+		g.metric(group+".latency_ms", s.P90Ms, ts, percentile("p90")),
+		g.metric(group+".latency_ms", s.P95Ms, ts, percentile("p95")),
+		g.metric(group+".latency_ms", s.P99Ms, ts, percentile("p99")),
+		g.metric(group+".latency_ms", s.P999Ms, ts, percentile("p99.9")),
+		g.metric(group+".latency_min_ms", s.MinMs, ts, tags),
+		g.metric(group+".latency_max_ms", s.MaxMs, ts, tags),
+		g.metric(group+".latency_mean_ms", s.MeanMs, ts, tags),
+	}
+}
+
+// PushSummary sends total and per rule stats of the run
+func (g *GraphitePusher) PushSummary(summary *Summary) error {
+	ts := summary.End
+	metrics := g.statsMetrics("summary", totalRule, ts, NewStatsReport(summary.Total))
+	for _, rule := range sortedKeys(summary.Rules) {
+		metrics = append(metrics, g.statsMetrics("summary", rule, ts, NewStatsReport(summary.Rules[rule]))...)
+	}
+	return graphite.Send(g.Addr, metrics, true)
+}
+
+// PushProgress sends interval stats
+func (g *GraphitePusher) PushProgress(entries []ProgressEntry) error {
+	metrics := make([]graphite.Metric, 0)
+	for _, e := range entries {
+		rule := e.Rule
+		if rule == "" {
+			rule = totalRule
+		}
+		tags := map[string]string{"rule": rule, "stage": e.Stage}
+		percentile := func(p string) map[string]string {
+			return map[string]string{"rule": rule, "stage": e.Stage, "percentile": p}
+		}
+		metrics = append(metrics,
+			g.metric("interval.count", float64(e.Count), e.Time, tags),
+			g.metric("interval.failed", float64(e.Failed), e.Time, tags),
+			g.metric("interval.rps", e.RPS, e.Time, tags),
+			g.metric("interval.error_rate", e.ErrorRate, e.Time, tags),
+			g.metric("interval.latency_ms", e.P50Ms, e.Time, percentile("p50")),
+			g.metric("interval.latency_ms", e.P99Ms, e.Time, percentile("p99")),
+		)
+	}
+	return graphite.Send(g.Addr, metrics, true)
+}
+
+// StartProgress starts sending queued interval stats in background,
+// so slow OR unreachable carbon doesn't stall processing of results
+func (g *GraphitePusher) StartProgress() {
+	g.queue = make(chan []ProgressEntry, progressQueue)
+	g.done = make(chan bool)
+	go func() {
+		for entries := range g.queue {
+			err := g.PushProgress(entries)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error while pushing progress to Graphite: %s\n", err)
+			}
+		}
+		g.done <- true
+	}()
+}
+
+// QueueProgress queues interval stats to send, they are dropped if the queue is full
+func (g *GraphitePusher) QueueProgress(entries []ProgressEntry) {
+	select {
+	case g.queue <- entries:
+	default:
+		fmt.Fprintf(os.Stderr, "Graphite push queue is full, interval stats dropped\n")
+	}
+}
+
+// StopProgress waits until queued interval stats are sent
+func (g *GraphitePusher) StopProgress() {
+	close(g.queue)
+	<-g.done
+}
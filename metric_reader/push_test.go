@@ -0,0 +1,78 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ifireice/metric_reader/graphite"
+)
+
+func TestGraphitePusherStatsMetrics(t *testing.T) {
+	g := NewGraphitePusher("localhost:2003", "mr", "run1", "Carbon")
+	ts := time.Unix(1600000000, 0)
+	metrics := g.statsMetrics("summary", "%s[1h]", ts, StatsReport{Count: 4, Failed: 1, P99Ms: 12.5})
+
+	lines := make(map[string]bool)
+	for _, m := range metrics {
+		lines[graphite.MetricToString(m, true)] = true
+	}
+	for _, line := range []string{
+		"mr.summary.count;rule=%s[1h];run_id=run1;source=Carbon 4 1600000000\n",
+		"mr.summary.error_rate;rule=%s[1h];run_id=run1;source=Carbon 0.25 1600000000\n",
+		"mr.summary.latency_ms;percentile=p99;rule=%s[1h];run_id=run1;source=Carbon 12.5 1600000000\n",
+	} {
+		if !lines[line] {
+			t.Errorf("Line '%s' not found in %v", line, lines)
+		}
+	}
+}
+
+func TestGraphitePusherQueue(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	received := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		data, _ := ioutil.ReadAll(conn)
+		received <- string(data)
+	}()
+
+	g := NewGraphitePusher(listener.Addr().String(), "mr", "run1", "Carbon")
+	g.StartProgress()
+	g.QueueProgress([]ProgressEntry{{Time: time.Unix(1600000000, 0), Stage: "main", Count: 3}})
+	g.StopProgress()
+	if data := <-received; !strings.Contains(data, "mr.interval.count;rule=total;run_id=run1;source=Carbon;stage=main 3 1600000000\n") {
+		t.Errorf("Not expected pushed data: %s", data)
+	}
+
+	// without sender full queue drops entries instead of blocking
+	g.queue = make(chan []ProgressEntry, progressQueue)
+	for i := 0; i < progressQueue+2; i++ {
+		g.QueueProgress(nil)
+	}
+	if len(g.queue) != progressQueue {
+		t.Errorf("Not expected queue length: %d", len(g.queue))
+	}
+}
+
+func TestCheckPushOptions(t *testing.T) {
+	if err := CheckPushOptions("", true); err == nil {
+		t.Error("Error should not be nil for interval stats without address")
+	}
+	if err := CheckPushOptions("localhost:2003", true); err != nil {
+		t.Error(err)
+	}
+	if err := CheckPushOptions("", false); err != nil {
+		t.Error(err)
+	}
+}
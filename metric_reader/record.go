@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ifireice/metric_reader/metric_reader/backend"
+)
+
+// RecordEntry is a recorded request, URL is relative to -url of the run
+// if it starts with it, so the stream can be replayed against another backend.
+// Request without URL is built on replay with query range shifted by time passed since Time
+type RecordEntry struct {
+	OffsetMs      float64     `json:"offset_ms"`
+	Method        string      `json:"method"`
+	URL           string      `json:"url"`
+	Header        http.Header `json:"header,omitempty"`
+	Body          []byte      `json:"body,omitempty"`
+	Rule          string      `json:"rule"`
+	Cache         string      `json:"cache,omitempty"`
+	Query         string      `json:"query"`
+	From          time.Time   `json:"from"`
+	Until         time.Time   `json:"until"`
+	Range         bool        `json:"range,omitempty"`
+	MaxDataPoints int         `json:"max_data_points,omitempty"`
+	Format        string      `json:"format,omitempty"`
+	StepMs        float64     `json:"step_ms,omitempty"`
+	Time          time.Time   `json:"time,omitempty"`
+	// LoggedMs is a query time logged by backend, it is compared with observed latency
+	LoggedMs float64 `json:"logged_ms,omitempty"`
+}
+
+// NewRecordEntry returns record of request scheduled after start
+func NewRecordEntry(request requestData, start time.Time, baseURL string) RecordEntry {
+	return RecordEntry{
+		OffsetMs:      toMs(request.Scheduled.Sub(start)),
+		Method:        request.Method,
+		URL:           strings.TrimPrefix(request.URL, baseURL),
+		Header:        request.Header,
+		Body:          request.Body,
+		Rule:          request.Rule,
+		Cache:         request.Cache,
+		Query:         request.Query.Expr,
+		From:          request.Query.From,
+		Until:         request.Query.Until,
+		Range:         request.Query.Range,
+		MaxDataPoints: request.Query.MaxDataPoints,
+		Format:        request.Query.Format,
+		StepMs:        toMs(request.Query.Step),
+	}
+}
+
+// Offset returns time from the start of the run to the request
+func (e RecordEntry) Offset() time.Duration {
+	return fromMs(e.OffsetMs)
+}
+
+// Request returns request to backend at baseURL
+func (e RecordEntry) Request(baseURL string) requestData {
+	var request requestData
+	request.Method = e.Method
+	request.URL = e.URL
+	if path := strings.SplitN(e.URL, "?", 2)[0]; e.URL != "" && !strings.Contains(path, "://") {
+		request.URL = baseURL + e.URL
+	}
+	request.Header = e.Header
+	request.Body = e.Body
+	request.Query = backend.Query{
+		Expr:          e.Query,
+		From:          e.From,
+		Until:         e.Until,
+		Range:         e.Range,
+		MaxDataPoints: e.MaxDataPoints,
+		Format:        e.Format,
+		Step:          fromMs(e.StepMs),
+	}
+	request.MetricName = e.Query
+	request.Rule = e.Rule
+	request.Cache = e.Cache
+	request.From = e.From
+	request.Until = e.Until
+	request.Logged = fromMs(e.LoggedMs)
+	return request
+}
+
+// Recorder writes request stream to NDJSON file
+type Recorder struct {
+	Start   time.Time
+	BaseURL string
+	file    *os.File
+	writer  *bufio.Writer
+	encoder *json.Encoder
+}
+
+// NewRecorder creates record file, offsets are counted from start
+func NewRecorder(path string, start time.Time, baseURL string) (*Recorder, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	writer := bufio.NewWriter(file)
+	return &Recorder{start, baseURL, file, writer, json.NewEncoder(writer)}, nil
+}
+
+// Write appends request to record
+func (r *Recorder) Write(request requestData) error {
+	return r.encoder.Encode(NewRecordEntry(request, r.Start, r.BaseURL))
+}
+
+// Close flushes and closes record file
+func (r *Recorder) Close() error {
+	err := r.writer.Flush()
+	if err != nil {
+		r.file.Close()
+		return err
+	}
+	return r.file.Close()
+}
+
+// ReadRecords reads record file, entries are sorted by offset
+func ReadRecords(path string) ([]RecordEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	records := make([]RecordEntry, 0)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var entry RecordEntry
+		err := json.Unmarshal(scanner.Bytes(), &entry)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", path, line, err)
+		}
+		records = append(records, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("Record '%s' has no requests", path)
+	}
+
+	// requests are recorded on completion, not in send order
+	sort.SliceStable(records, func(i, j int) bool { return records[i].OffsetMs < records[j].OffsetMs })
+	return records, nil
+}
+
+// replayRequests sends count (all if 0) recorded requests to outChan at their offsets
+// from the profile start divided by speed. Like scheduleRequests, requests which don't fit
+// into outChan are dropped and sent directly to resultChan. With zero speed requests are sent
+// as soon as workers are free. Requests without URL are built by b when sent.
+// Sends true to doneChan when stopped.
+func replayRequests(ctx context.Context, profile *LoadProfile, b backend.Backend, records []RecordEntry, baseURL string, speed float64, count uint64, outChan chan requestData, resultChan chan requestData, doneChan chan bool) {
+	defer func() {
+		close(outChan)
+		doneChan <- true
+	}()
+	if count > 0 && count < uint64(len(records)) {
+		records = records[:count]
+	}
+
+	for _, record := range records {
+		if ctx.Err() != nil {
+			return
+		}
+		request := record.Request(baseURL)
+		// range is shifted to send time, which is unknown in advance if waiting for workers
+		shift := func() {
+			if record.URL == "" {
+				request = shiftRequest(b, request, time.Since(record.Time).Truncate(time.Second))
+			}
+		}
+
+		if speed <= 0 {
+			shift()
+			select {
+			case outChan <- request:
+			case <-ctx.Done():
+				return
+			}
+			continue
+		}
+
+		request.Scheduled = profile.Start.Add(time.Duration(float64(record.Offset()) / speed))
+		if wait := time.Until(request.Scheduled); wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			}
+		}
+		shift()
+
+		select {
+		case outChan <- request:
+		default:
+			request.Stage = profile.At(request.Scheduled).Name
+			request.Dropped = true
+			resultChan <- request
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ifireice/metric_reader/metric_reader/backend"
+	"github.com/ifireice/metric_reader/metric_reader/carbon"
+)
+
+// generate returns count requests generated with seed
+func generate(t *testing.T, seed int64, count uint64) []requestData {
+	b := carbon.New("http://localhost")
+	corpus := &Corpus{Source: b.Name(), Series: make([]string, 0)}
+	for i := 0; i < 100; i++ {
+		corpus.Series = append(corpus.Series, fmt.Sprintf("'name=m%d','dc=dc%d'", i, i%3))
+	}
+	corpus.Index(b)
+	dist, _ := ParseSeriesDist("zipf:1.2")
+	random := rand.New(rand.NewSource(seed))
+	corpus.SetDist(dist, random)
+
+	rules := []Rule{
+		{MetricQueryTemplate: "seriesByTag(%s)", Period: time.Hour, MaxPeriod: 24 * time.Hour, Weight: 1},
+		{MetricQueryTemplate: `sumSeries(seriesByTag({{tag "dc"}}))`, Period: time.Hour, Weight: 1},
+	}
+	window, _ := ParseTimeWindow(UniformWindow)
+	cache := NewReplayCache(0.5, 10, NoBust, random)
+
+	out := make(chan requestData)
+	go generateRequests(context.Background(), b, corpus, NewRulePicker(rules, random), cache, window, count, 168*time.Hour, 100, random, out)
+	requests := make([]requestData, 0)
+	for request := range out {
+		requests = append(requests, request)
+	}
+	if uint64(len(requests)) != count {
+		t.Fatalf("Not expected requests count: %d", len(requests))
+	}
+	return requests
+}
+
+func TestGenerateRequestsSeed(t *testing.T) {
+	first := generate(t, 42, 100)
+	second := generate(t, 42, 100)
+	other := generate(t, 43, 100)
+
+	same := 0
+	for i := range first {
+		a, b := first[i], second[i]
+		if a.MetricName != b.MetricName || a.Rule != b.Rule || a.Cache != b.Cache || a.Key != b.Key || a.Until.Sub(a.From) != b.Until.Sub(b.From) {
+			t.Fatalf("Request %d differs: %+v != %+v", i, a, b)
+		}
+		if a.MetricName == other[i].MetricName {
+			same++
+		}
+	}
+	if same == len(first) {
+		t.Error("Requests should differ for other seed")
+	}
+}
+
+func TestRecordReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "record.ndjson")
+	start := time.Now()
+	recorder, err := NewRecorder(path, start, "http://a:8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := carbon.New("http://a:8080")
+	for i, offset := range []time.Duration{2 * time.Second, time.Second, 3 * time.Second} {
+		query := backend.Query{Expr: fmt.Sprintf("m%d", i), From: start.Add(-time.Hour), Until: start, Format: "csv"}
+		built := b.BuildRequest(query)
+		request := requestData{Method: built.Method, URL: built.URL, Query: query, MetricName: query.Expr, Rule: "r", Scheduled: start.Add(offset)}
+		if err := recorder.Write(request); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := ReadRecords(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 || records[0].Query != "m1" || records[0].Offset() != time.Second || records[2].Query != "m2" {
+		t.Fatalf("Not expected records: %+v", records)
+	}
+
+	request := records[0].Request("http://b:9090")
+	expected := carbon.New("http://b:9090").BuildRequest(request.Query).URL
+	if request.URL != expected || request.Query.Format != "csv" || request.Rule != "r" {
+		t.Errorf("Not expected replayed request: %+v", request)
+	}
+
+	out := make(chan requestData, 3)
+	done := make(chan bool, 1)
+	profile := NewLoadProfile(nil, 0, 1)
+	replayRequests(context.Background(), profile, carbon.New("http://b:9090"), records, "http://b:9090", 0, 2, out, nil, done)
+	<-done
+	replayed := make([]string, 0)
+	for request := range out {
+		replayed = append(replayed, request.MetricName)
+	}
+	if len(replayed) != 2 || replayed[0] != "m1" || replayed[1] != "m0" {
+		t.Errorf("Not expected replayed requests: %v", replayed)
+	}
+}
@@ -2,16 +2,60 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/json"
 	"fmt"
-	"os"
+	"io/ioutil"
+	"math"
+	"math/rand"
 	"regexp"
+	"sort"
+	"strings"
 	"time"
 )
 
-// Rule is a parsed rule string
+// Query modes for rule
+const (
+	InstantMode = "instant"
+	RangeMode   = "range"
+)
+
+// Period distributions of rule
+const (
+	UniformDistribution    = "uniform"
+	LogUniformDistribution = "log_uniform"
+)
+
+// WeightedPeriod is a query period with its share in rule requests
+type WeightedPeriod struct {
+	Period time.Duration
+	Weight float64
+}
+
+// Rule is a parsed rule string or rules file entry
 type Rule struct {
+	// Name is a rule name in stats, default: rule string
+	Name                string
 	MetricQueryTemplate string
-	Period              time.Duration
+	// Period is a fixed query period, or min period if MaxPeriod is set
+	Period time.Duration
+	// MaxPeriod makes period random between Period and MaxPeriod
+	MaxPeriod    time.Duration
+	Distribution string
+	// Periods are weighted query periods, override Period if set
+	Periods []WeightedPeriod
+	// Window picks query time range, default: -window option
+	Window *TimeWindow
+	// Range is true for range queries (Prometheus query_range)
+	Range bool
+	// Weight is a share of the rule in requests
+	Weight float64
+	// Source restricts rule to backend, default: any
+	Source string
+	// Request params, default: command line options
+	MaxDataPoints int
+	Format        string
+	Step          time.Duration
 }
 
 // MakeRule returs Rule from metricQuery and period str
@@ -21,15 +65,25 @@ func MakeRule(metricQuery string, periodStr string) *Rule {
 		return nil
 	}
 
-	return &Rule{metricQuery, period}
+	return &Rule{MetricQueryTemplate: metricQuery, Period: period, Weight: 1}
 }
 
-// ParseRule parses rule string and returns Rule struct
+// MakeRangeRule returs range query Rule from metricQuery and period str
+func MakeRangeRule(metricQuery string, periodStr string) *Rule {
+	rule := MakeRule(metricQuery, periodStr)
+	if rule != nil {
+		rule.Range = true
+	}
+	return rule
+}
+
+// ParseRule parses rule string and returns Rule struct.
+// Rule format is 'template[period]' with optional ' instant' or ' range' mode suffix
 func ParseRule(rule string) (*Rule, error) {
-	ruleRe := regexp.MustCompile("^(.*)\\[(.*)\\]$")
+	ruleRe := regexp.MustCompile("^(.*)\\[(.*)\\](?:\\s+(" + InstantMode + "|" + RangeMode + "))?$")
 
 	ruleParsed := ruleRe.FindStringSubmatch(rule)
-	if len(ruleParsed) != 3 {
+	if len(ruleParsed) != 4 {
 		return nil, fmt.Errorf("Cant parse rule: '%s'", rule)
 	}
 	metricQueryTemplate := ruleParsed[1]
@@ -39,13 +93,76 @@ func ParseRule(rule string) (*Rule, error) {
 	if err != nil {
 		return nil, err
 	}
+	err = ValidateTemplate(metricQueryTemplate)
+	if err != nil {
+		return nil, err
+	}
 
-	ret := Rule{metricQueryTemplate, period}
+	ret := Rule{MetricQueryTemplate: metricQueryTemplate, Period: period, Range: ruleParsed[3] == RangeMode, Weight: 1}
 
 	return &ret, nil
 }
 
-// Template2Metric apply metric name to template
+// Label returns rule name in stats
+func (r Rule) Label() string {
+	if r.Name != "" {
+		return r.Name
+	}
+	return r.String()
+}
+
+// RandomPeriod returns query period of the next request
+func (r Rule) RandomPeriod(random *rand.Rand) time.Duration {
+	if len(r.Periods) > 0 {
+		total := float64(0)
+		for _, p := range r.Periods {
+			total += p.Weight
+		}
+		x := random.Float64() * total
+		for _, p := range r.Periods {
+			if x < p.Weight {
+				return p.Period
+			}
+			x -= p.Weight
+		}
+		return r.Periods[len(r.Periods)-1].Period
+	}
+
+	if r.MaxPeriod <= r.Period {
+		return r.Period
+	}
+	if r.Distribution == LogUniformDistribution {
+		min := math.Log(float64(r.Period))
+		max := math.Log(float64(r.MaxPeriod))
+		return time.Duration(math.Exp(min + random.Float64()*(max-min))).Round(time.Second)
+	}
+	return r.Period + time.Duration(random.Int63n(int64(r.MaxPeriod-r.Period)))
+}
+
+// String returns rule in rules file format. Weighted periods are joined with '|'
+// and random period is 'min-max', e.g. '%s[1h0m0s|24h0m0s]'
+func (r Rule) String() string {
+	if r.Range {
+		return fmt.Sprintf("%s[%s] %s", r.MetricQueryTemplate, r.periodString(), RangeMode)
+	}
+	return fmt.Sprintf("%s[%s]", r.MetricQueryTemplate, r.periodString())
+}
+
+func (r Rule) periodString() string {
+	if len(r.Periods) > 0 {
+		periods := make([]string, 0, len(r.Periods))
+		for _, p := range r.Periods {
+			periods = append(periods, p.Period.String())
+		}
+		return strings.Join(periods, "|")
+	}
+	if r.MaxPeriod > r.Period {
+		return fmt.Sprintf("%s-%s", r.Period, r.MaxPeriod)
+	}
+	return r.Period.String()
+}
+
+// Template2Metric apply metric name to '%s' template, see ExpandTemplate for named placeholders
 func Template2Metric(metricTemplate string, metricName string) string {
 	return fmt.Sprintf(metricTemplate, metricName)
 }
@@ -55,16 +172,149 @@ func GetDefaultRule() Rule {
 	return *MakeRule("%s", "24h")
 }
 
-// ReadRules reads rules from file and parse it
+// ruleConfig is a rule of JSON rules file, durations are strings like '1h'
+type ruleConfig struct {
+	Name     string   `json:"name"`
+	Template string   `json:"template"`
+	Weight   *float64 `json:"weight"`
+	Source   string   `json:"source"`
+	Mode     string   `json:"mode"`
+	// Period is a fixed period, or min period with MaxPeriod
+	Period       string `json:"period"`
+	MaxPeriod    string `json:"max_period"`
+	Distribution string `json:"distribution"`
+	Periods      []struct {
+		Period string  `json:"period"`
+		Weight float64 `json:"weight"`
+	} `json:"periods"`
+	Window        string `json:"window"`
+	MaxDataPoints int    `json:"max_data_points"`
+	Format        string `json:"format"`
+	Step          string `json:"step"`
+}
+
+func parseOptionalDuration(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(value)
+}
+
+// Rule returns rule of config entry
+func (c ruleConfig) Rule() (*Rule, error) {
+	if c.Template == "" {
+		return nil, fmt.Errorf("Rule '%s' has no template", c.Name)
+	}
+	if err := ValidateTemplate(c.Template); err != nil {
+		return nil, err
+	}
+	rule := &Rule{
+		Name:                c.Name,
+		MetricQueryTemplate: c.Template,
+		Weight:              1,
+		Source:              c.Source,
+		Distribution:        c.Distribution,
+		MaxDataPoints:       c.MaxDataPoints,
+		Format:              c.Format,
+	}
+	if c.Weight != nil {
+		rule.Weight = *c.Weight
+	}
+	if rule.Weight < 0 {
+		return nil, fmt.Errorf("Rule '%s' has negative weight", rule.Label())
+	}
+
+	switch c.Mode {
+	case "", InstantMode:
+	case RangeMode:
+		rule.Range = true
+	default:
+		return nil, fmt.Errorf("Unknown mode '%s' of rule '%s'", c.Mode, rule.Label())
+	}
+
+	switch c.Distribution {
+	case "", UniformDistribution, LogUniformDistribution:
+	default:
+		return nil, fmt.Errorf("Unknown distribution '%s' of rule '%s'", c.Distribution, rule.Label())
+	}
+
+	var err error
+	if rule.Period, err = parseOptionalDuration(c.Period); err != nil {
+		return nil, err
+	}
+	if rule.MaxPeriod, err = parseOptionalDuration(c.MaxPeriod); err != nil {
+		return nil, err
+	}
+	if rule.Step, err = parseOptionalDuration(c.Step); err != nil {
+		return nil, err
+	}
+	if c.Window != "" {
+		if rule.Window, err = ParseTimeWindow(c.Window); err != nil {
+			return nil, err
+		}
+	}
+	for _, p := range c.Periods {
+		period, err := time.ParseDuration(p.Period)
+		if err != nil {
+			return nil, err
+		}
+		if p.Weight <= 0 {
+			return nil, fmt.Errorf("Period '%s' of rule '%s' has no weight", p.Period, rule.Label())
+		}
+		rule.Periods = append(rule.Periods, WeightedPeriod{period, p.Weight})
+	}
+
+	if rule.Period <= 0 && len(rule.Periods) == 0 {
+		return nil, fmt.Errorf("Rule '%s' has no period", rule.Label())
+	}
+	if rule.MaxPeriod > 0 && rule.MaxPeriod < rule.Period {
+		return nil, fmt.Errorf("Rule '%s' has max_period less than period", rule.Label())
+	}
+	if rule.Distribution == LogUniformDistribution && rule.Period <= 0 {
+		return nil, fmt.Errorf("Rule '%s' needs positive period for log_uniform distribution", rule.Label())
+	}
+	return rule, nil
+}
+
+// ParseRulesJSON parses JSON rules file: list of rules or object with "rules" list
+func ParseRulesJSON(data []byte) ([]Rule, error) {
+	ret := make([]Rule, 0)
+	var configs []ruleConfig
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		var file struct {
+			Rules []ruleConfig `json:"rules"`
+		}
+		if err := json.Unmarshal(data, &file); err != nil {
+			return ret, err
+		}
+		configs = file.Rules
+	} else if err := json.Unmarshal(data, &configs); err != nil {
+		return ret, err
+	}
+
+	for _, c := range configs {
+		rule, err := c.Rule()
+		if err != nil {
+			return ret, err
+		}
+		ret = append(ret, *rule)
+	}
+	return ret, nil
+}
+
+// ReadRules reads rules from file and parse it.
+// File is JSON (see ruleConfig) if it starts with '[' or '{', otherwise one rule string per line
 func ReadRules(filepath string) ([]Rule, error) {
 	ret := make([]Rule, 0)
-	file, err := os.Open(filepath)
+	data, err := ioutil.ReadFile(filepath)
 	if err != nil {
 		return ret, err
 	}
-	defer file.Close()
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && (trimmed[0] == '[' || trimmed[0] == '{') {
+		return ParseRulesJSON(data)
+	}
 
-	scanner := bufio.NewScanner(file)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
 	for scanner.Scan() {
 		text := scanner.Text()
 		if text == "" {
@@ -79,3 +329,43 @@ func ReadRules(filepath string) ([]Rule, error) {
 	}
 	return ret, nil
 }
+
+// FilterRules returns rules for source and with positive weight
+func FilterRules(rules []Rule, source string) []Rule {
+	ret := make([]Rule, 0, len(rules))
+	for _, r := range rules {
+		if r.Weight > 0 && (r.Source == "" || strings.EqualFold(r.Source, source)) {
+			ret = append(ret, r)
+		}
+	}
+	return ret
+}
+
+// RulePicker picks rules at random proportionally to weights
+type RulePicker struct {
+	rules      []Rule
+	cumulative []float64
+	random     *rand.Rand
+}
+
+// NewRulePicker returns picker of rules, rules should have positive weight
+func NewRulePicker(rules []Rule, r *rand.Rand) *RulePicker {
+	p := &RulePicker{rules: rules, cumulative: make([]float64, len(rules)), random: r}
+	total := float64(0)
+	for i, r := range rules {
+		total += r.Weight
+		p.cumulative[i] = total
+	}
+	return p
+}
+
+// Pick returns random rule
+func (p *RulePicker) Pick() *Rule {
+	x := p.random.Float64() * p.cumulative[len(p.cumulative)-1]
+	// first rule with cumulative weight above x
+	i := sort.Search(len(p.cumulative), func(i int) bool { return p.cumulative[i] > x })
+	if i == len(p.cumulative) {
+		i--
+	}
+	return &p.rules[i]
+}
@@ -1,7 +1,10 @@
 package main
 
 import (
+	"math/rand"
+	"reflect"
 	"testing"
+	"time"
 )
 
 func CheckParseRule(rule string, expected *Rule, t *testing.T) {
@@ -11,7 +14,7 @@ func CheckParseRule(rule string, expected *Rule, t *testing.T) {
 		panic(err)
 	}
 
-	if *expected != *parsed {
+	if !reflect.DeepEqual(*expected, *parsed) {
 		t.Errorf("Not expected result: %s != %s", *parsed, expected)
 	}
 }
@@ -28,6 +31,8 @@ func TestParseRuleOk(t *testing.T) {
 	CheckParseRule("MySuperTest(%s)[1s]", MakeRule("MySuperTest(%s)", "1s"), t)
 	CheckParseRule("%s[1m]", MakeRule("%s", "1m"), t)
 	CheckParseRule("%s[48h]", MakeRule("%s", "48h"), t)
+	CheckParseRule("rate(%s[5m])[1h] range", MakeRangeRule("rate(%s[5m])", "1h"), t)
+	CheckParseRule("rate(%s[5m])[1h] instant", MakeRule("rate(%s[5m])", "1h"), t)
 }
 
 func TestParseRuleBad(t *testing.T) {
@@ -37,4 +42,95 @@ func TestParseRuleBad(t *testing.T) {
 	CheckParseBadRule("haha[1m", t)
 	CheckParseBadRule("haha1m]", t)
 	CheckParseBadRule("haha[1mqwerqwerqw]", t)
+	CheckParseBadRule("haha[1m] sometimes", t)
+}
+
+func TestParseRulesJSON(t *testing.T) {
+	rules, err := ParseRulesJSON([]byte(`{"rules": [
+		{"name": "overview", "template": "sumSeries(%s)", "weight": 3, "period": "1h", "max_period": "24h", "distribution": "log_uniform"},
+		{"template": "rate(%s[5m])", "mode": "range", "source": "prometheus", "step": "1m", "max_data_points": 500,
+			"periods": [{"period": "1h", "weight": 9}, {"period": "168h", "weight": 1}]},
+		{"template": "%s", "period": "6h", "weight": 0, "format": "csv", "window": "aligned:1h"}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 3 {
+		t.Fatalf("Not expected rules count: %d", len(rules))
+	}
+
+	if rules[0].Label() != "overview" || rules[0].Weight != 3 || rules[0].MaxPeriod != 24*time.Hour {
+		t.Errorf("Not expected rule: %+v", rules[0])
+	}
+	if !rules[1].Range || rules[1].Step != time.Minute || rules[1].MaxDataPoints != 500 || len(rules[1].Periods) != 2 {
+		t.Errorf("Not expected rule: %+v", rules[1])
+	}
+	if rules[2].Weight != 0 || rules[2].Format != "csv" || rules[2].Window.String() != "aligned:1h0m0s" || rules[0].Window != nil {
+		t.Errorf("Not expected rule: %+v", rules[2])
+	}
+
+	if filtered := FilterRules(rules, "Carbon"); len(filtered) != 1 || filtered[0].Label() != "overview" {
+		t.Errorf("Not expected rules for Carbon: %+v", filtered)
+	}
+	if filtered := FilterRules(rules, "Prometheus"); len(filtered) != 2 {
+		t.Errorf("Not expected rules for Prometheus: %+v", filtered)
+	}
+
+	for _, bad := range []string{
+		`[{"template": "%s"}]`,
+		`[{"period": "1h"}]`,
+		`[{"template": "%s", "period": "1h", "mode": "sometimes"}]`,
+		`[{"template": "%s", "period": "1h", "weight": -1}]`,
+		`[{"template": "%s", "period": "24h", "max_period": "1h"}]`,
+		`[{"template": "%s", "periods": [{"period": "1h"}]}]`,
+		`[{"template": "%s", "period": "1h", "window": "future"}]`,
+	} {
+		if _, err := ParseRulesJSON([]byte(bad)); err == nil {
+			t.Errorf("Error should not be nil for rules '%s'", bad)
+		}
+	}
+}
+
+func TestRuleRandomPeriod(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	rule := Rule{Period: time.Hour, MaxPeriod: 24 * time.Hour, Distribution: LogUniformDistribution}
+	for i := 0; i < 100; i++ {
+		if p := rule.RandomPeriod(r); p < time.Hour || p > 24*time.Hour {
+			t.Fatalf("Period out of range: %s", p)
+		}
+	}
+
+	rule = Rule{Periods: []WeightedPeriod{{time.Hour, 1}, {24 * time.Hour, 0.001}}}
+	hours := 0
+	for i := 0; i < 1000; i++ {
+		if rule.RandomPeriod(r) == time.Hour {
+			hours++
+		}
+	}
+	if hours < 950 {
+		t.Errorf("Weighted periods are not respected: %d of 1000", hours)
+	}
+}
+
+func TestRuleLabel(t *testing.T) {
+	check := func(rule Rule, expected string) {
+		if label := rule.Label(); label != expected {
+			t.Errorf("Label: %s != %s", label, expected)
+		}
+	}
+	check(Rule{MetricQueryTemplate: "%s", Period: time.Hour}, "%s[1h0m0s]")
+	check(Rule{MetricQueryTemplate: "rate(%s[5m])", Periods: []WeightedPeriod{{time.Hour, 3}, {24 * time.Hour, 1}}, Range: true}, "rate(%s[5m])[1h0m0s|24h0m0s] range")
+	check(Rule{MetricQueryTemplate: "%s", Period: time.Hour, MaxPeriod: 24 * time.Hour}, "%s[1h0m0s-24h0m0s]")
+	check(Rule{Name: "a", MetricQueryTemplate: "%s"}, "a")
+}
+
+func TestRulePicker(t *testing.T) {
+	picker := NewRulePicker([]Rule{{Name: "a", Weight: 9}, {Name: "b", Weight: 1}}, rand.New(rand.NewSource(1)))
+	counts := make(map[string]int)
+	for i := 0; i < 10000; i++ {
+		counts[picker.Pick().Name]++
+	}
+	if counts["a"] < 8500 || counts["a"] > 9500 {
+		t.Errorf("Not expected picks: %v", counts)
+	}
 }
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// lateThreshold is a delay after intended send time when request is counted as late
+const lateThreshold = 10 * time.Millisecond
+
+// nextArrival returns interval to the next request for target rate
+func nextArrival(rate float64, poisson bool, r *rand.Rand) time.Duration {
+	if poisson {
+		return time.Duration(r.ExpFloat64() / rate * float64(time.Second))
+	}
+	return time.Duration(float64(time.Second) / rate)
+}
+
+// scheduleRequests passes requests from inChan to outChan at rate of current profile stage (open model).
+// Every request gets its intended send time, so latency is measured from schedule
+// even if workers are busy. Requests which don't fit into outChan are dropped
+// and sent directly to resultChan. Poisson arrivals are drawn from r. Sends true to doneChan when stopped.
+func scheduleRequests(ctx context.Context, profile *LoadProfile, inChan chan requestData, outChan chan requestData, resultChan chan requestData, doneChan chan bool, poisson bool, r *rand.Rand) {
+	defer func() {
+		close(outChan)
+		doneChan <- true
+	}()
+	next := time.Now()
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+		if wait := time.Until(next); wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			}
+		}
+
+		request, more := <-inChan
+		if !more {
+			break
+		}
+		request.Scheduled = next
+		stage := profile.At(next)
+
+		select {
+		case outChan <- request:
+		default:
+			request.Stage = stage.Name
+			request.Dropped = true
+			resultChan <- request
+		}
+
+		next = next.Add(nextArrival(stage.Level, poisson, r))
+	}
+}
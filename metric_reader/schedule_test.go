@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestNextArrival(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	if d := nextArrival(50, false, r); d != 20*time.Millisecond {
+		t.Errorf("Not expected constant arrival: %s", d)
+	}
+
+	total := time.Duration(0)
+	distinct := make(map[time.Duration]bool)
+	for i := 0; i < 10000; i++ {
+		d := nextArrival(50, true, r)
+		total += d
+		distinct[d] = true
+	}
+	if mean := total / 10000; mean < 19*time.Millisecond || mean > 21*time.Millisecond || len(distinct) < 1000 {
+		t.Errorf("Not expected poisson arrivals: mean %s, %d distinct", mean, len(distinct))
+	}
+}
+
+// schedule passes count requests through scheduler at rate with outChan of backlog size,
+// nothing reads outChan until the scheduler is done. Returns queued and dropped requests
+func schedule(count int, rate float64, backlog int, poisson bool) ([]requestData, []requestData, time.Duration) {
+	inChan := make(chan requestData, count)
+	for i := 0; i < count; i++ {
+		inChan <- requestData{Rule: "r"}
+	}
+	close(inChan)
+	outChan := make(chan requestData, backlog)
+	resultChan := make(chan requestData, count)
+	doneChan := make(chan bool, 1)
+
+	start := time.Now()
+	scheduleRequests(context.Background(), NewLoadProfile(nil, 0, rate), inChan, outChan, resultChan, doneChan, poisson, rand.New(rand.NewSource(1)))
+	elapsed := time.Since(start)
+	<-doneChan
+	close(resultChan)
+
+	queued := make([]requestData, 0)
+	for request := range outChan {
+		queued = append(queued, request)
+	}
+	dropped := make([]requestData, 0)
+	for request := range resultChan {
+		dropped = append(dropped, request)
+	}
+	return queued, dropped, elapsed
+}
+
+func TestScheduleRequests(t *testing.T) {
+	queued, dropped, elapsed := schedule(20, 100, 2, false)
+	if len(queued) != 2 || len(dropped) != 18 {
+		t.Fatalf("Not expected queued %d and dropped %d", len(queued), len(dropped))
+	}
+	// the last request is sent 19 intervals after the first one
+	if elapsed < 180*time.Millisecond {
+		t.Errorf("Rate is not respected: 20 requests in %s", elapsed)
+	}
+	for _, request := range dropped {
+		if !request.Dropped || request.Stage != "main" {
+			t.Errorf("Not expected dropped request: %+v", request)
+		}
+	}
+
+	// requests are stamped with intended send time, not with time of reading from queue
+	all := append(queued, dropped...)
+	sort.Slice(all, func(i, j int) bool { return all[i].Scheduled.Before(all[j].Scheduled) })
+	for i := 1; i < len(all); i++ {
+		if d := all[i].Scheduled.Sub(all[i-1].Scheduled); d != 10*time.Millisecond {
+			t.Errorf("Not expected interval between requests %d and %d: %s", i-1, i, d)
+		}
+	}
+	if time.Since(queued[0].Scheduled) < elapsed-10*time.Millisecond {
+		t.Errorf("Queued request is stamped late: %s", queued[0].Scheduled)
+	}
+}
+
+func TestScheduleRequestsPoisson(t *testing.T) {
+	queued, dropped, _ := schedule(20, 200, 20, true)
+	if len(queued) != 20 || len(dropped) != 0 {
+		t.Fatalf("Not expected queued %d and dropped %d", len(queued), len(dropped))
+	}
+	intervals := make(map[time.Duration]bool)
+	for i := 1; i < len(queued); i++ {
+		d := queued[i].Scheduled.Sub(queued[i-1].Scheduled)
+		if d < 0 {
+			t.Fatalf("Requests are not ordered by schedule: %s", d)
+		}
+		intervals[d] = true
+	}
+	if len(intervals) < 10 {
+		t.Errorf("Poisson intervals should differ: %v", intervals)
+	}
+}
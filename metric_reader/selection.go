@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// Series selection distributions
+const (
+	UniformDist = "uniform"
+	ZipfDist    = "zipf"
+	HotSetDist  = "hotset"
+)
+
+// SeriesDist is a distribution of series picks over corpus:
+// 'uniform', 'zipf:skew' (skew > 1) OR 'hotset:size:fraction',
+// e.g. 'hotset:0.01:0.9' sends 90% of picks to 1% of series
+type SeriesDist struct {
+	Name        string
+	Skew        float64
+	HotSet      float64
+	HotFraction float64
+}
+
+// ParseSeriesDist parses series distribution spec
+func ParseSeriesDist(spec string) (*SeriesDist, error) {
+	parts := strings.Split(spec, ":")
+	params := make([]float64, 0, len(parts)-1)
+	for _, p := range parts[1:] {
+		v, err := strconv.ParseFloat(p, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Cant parse series distribution '%s': %s", spec, err)
+		}
+		params = append(params, v)
+	}
+
+	d := &SeriesDist{Name: parts[0]}
+	switch {
+	case d.Name == UniformDist && len(params) == 0:
+	case d.Name == ZipfDist && len(params) == 1:
+		d.Skew = params[0]
+		if d.Skew <= 1 {
+			return nil, fmt.Errorf("Zipf skew should be greater than 1: '%s'", spec)
+		}
+	case d.Name == HotSetDist && len(params) == 2:
+		d.HotSet, d.HotFraction = params[0], params[1]
+		if d.HotSet <= 0 || d.HotSet > 1 || d.HotFraction < 0 || d.HotFraction > 1 {
+			return nil, fmt.Errorf("Hot set size and fraction should be in (0, 1]: '%s'", spec)
+		}
+	default:
+		return nil, fmt.Errorf("Cant parse series distribution '%s'", spec)
+	}
+	return d, nil
+}
+
+// String returns distribution spec
+func (d *SeriesDist) String() string {
+	switch d.Name {
+	case ZipfDist:
+		return fmt.Sprintf("%s:%g", d.Name, d.Skew)
+	case HotSetDist:
+		return fmt.Sprintf("%s:%g:%g", d.Name, d.HotSet, d.HotFraction)
+	}
+	return d.Name
+}
+
+// NewPicker returns function picking index of n items.
+// Items are ranked in random order, so the hot ones are not the first ones
+func (d *SeriesDist) NewPicker(n int, r *rand.Rand) func() int {
+	rank := r.Perm(n)
+	switch d.Name {
+	case ZipfDist:
+		zipf := rand.NewZipf(r, d.Skew, 1, uint64(n-1))
+		return func() int {
+			return rank[zipf.Uint64()]
+		}
+	case HotSetDist:
+		hot := int(math.Round(float64(n) * d.HotSet))
+		if hot < 1 {
+			hot = 1
+		}
+		return func() int {
+			if hot == n || r.Float64() < d.HotFraction {
+				return rank[r.Intn(hot)]
+			}
+			return rank[hot+r.Intn(n-hot)]
+		}
+	}
+	return func() int {
+		return r.Intn(n)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/ifireice/metric_reader/metric_reader/carbon"
+)
+
+func TestParseSeriesDist(t *testing.T) {
+	for _, good := range []string{"uniform", "zipf:1.2", "hotset:0.01:0.9", "hotset:1:1"} {
+		d, err := ParseSeriesDist(good)
+		if err != nil {
+			t.Errorf("Distribution '%s': %s", good, err)
+			continue
+		}
+		if d.String() != good {
+			t.Errorf("Not expected distribution: %s != %s", d, good)
+		}
+	}
+	for _, bad := range []string{"", "zipf", "zipf:1", "zipf:x", "hotset:0.1", "hotset:0:0.9", "hotset:0.1:2", "uniform:1", "normal"} {
+		if _, err := ParseSeriesDist(bad); err == nil {
+			t.Errorf("Error should not be nil for distribution '%s'", bad)
+		}
+	}
+}
+
+// topShare returns share of picks of the most picked k items
+func topShare(pick func() int, n int, k int, picks int) float64 {
+	counts := make([]int, n)
+	for i := 0; i < picks; i++ {
+		counts[pick()]++
+	}
+	top := 0
+	for j := 0; j < k; j++ {
+		best := 0
+		for i := range counts {
+			if counts[i] > counts[best] {
+				best = i
+			}
+		}
+		top += counts[best]
+		counts[best] = -1
+	}
+	return float64(top) / float64(picks)
+}
+
+func TestSeriesDistPicker(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	hotset, _ := ParseSeriesDist("hotset:0.01:0.9")
+	if share := topShare(hotset.NewPicker(1000, r), 1000, 10, 20000); share < 0.88 || share > 0.92 {
+		t.Errorf("Hot set share: %v", share)
+	}
+
+	zipf, _ := ParseSeriesDist("zipf:1.5")
+	if share := topShare(zipf.NewPicker(1000, r), 1000, 10, 20000); share < 0.7 {
+		t.Errorf("Zipf top 10 share: %v", share)
+	}
+
+	uniform, _ := ParseSeriesDist("uniform")
+	if share := topShare(uniform.NewPicker(1000, r), 1000, 10, 20000); share > 0.05 {
+		t.Errorf("Uniform top 10 share: %v", share)
+	}
+
+	single := zipf.NewPicker(1, r)
+	if single() != 0 {
+		t.Error("Single item should be picked")
+	}
+}
+
+func TestCorpusTagValueDist(t *testing.T) {
+	b := carbon.New("http://localhost")
+	corpus := &Corpus{Source: b.Name()}
+	for i := 0; i < 1000; i++ {
+		corpus.Series = append(corpus.Series, fmt.Sprintf("'host=h%d'", i))
+	}
+	corpus.Index(b)
+	hotset, _ := ParseSeriesDist("hotset:0.01:0.9")
+	corpus.SetDist(hotset, rand.New(rand.NewSource(1)))
+
+	index := make(map[string]int, len(corpus.tags["host"]))
+	for i, v := range corpus.tags["host"] {
+		index[v] = i
+	}
+	pick := func() int {
+		value, err := corpus.RandomTagValue("host")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return index[value]
+	}
+	if share := topShare(pick, 1000, 10, 20000); share < 0.88 || share > 0.92 {
+		t.Errorf("Hot set share of tag values: %v", share)
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Assertion is a threshold for a summary metric, e.g. 'p99<500ms'.
+// With Rule regexp it is checked for every matching rule instead of total
+type Assertion struct {
+	Metric    string
+	Op        string
+	Threshold float64
+	Rule      *regexp.Regexp
+	text      string
+}
+
+// noMatchingRule is a group of failed result of per rule assertion matching no rule, e.g. a typo
+const noMatchingRule = "(no matching rule)"
+
+// AssertionResult is an assertion checked against one group of requests
+type AssertionResult struct {
+	Assertion *Assertion
+	Group     string
+	Value     float64
+	Passed    bool
+}
+
+var assertionRe = regexp.MustCompile(`^\s*([a-z_0-9.]+)\s*(<=|>=|<|>)\s*(\S+)(?:\s+for\s+(.+?))?\s*$`)
+
+// durationMetrics are latency metrics, thresholds are durations
+var durationMetrics = map[string]float64{
+	"min": -1, "p50": 0.5, "p90": 0.9, "p95": 0.95, "p99": 0.99, "p99.9": 0.999, "max": -1, "mean": -1,
+}
+
+// rateMetrics are fractions of requests, thresholds are percents or fractions
+var rateMetrics = map[string]bool{"error_rate": true, "late_rate": true, "drop_rate": true}
+
+// ParseAssertion parses assertion 'metric op value [for rule_regexp]', e.g.
+// 'p99<500ms', 'error_rate<1%', 'rps>200', 'p95<=1s for ^sumSeries'
+func ParseAssertion(text string) (*Assertion, error) {
+	parsed := assertionRe.FindStringSubmatch(text)
+	if len(parsed) != 5 {
+		return nil, fmt.Errorf("Cant parse assertion: '%s'", text)
+	}
+
+	a := &Assertion{Metric: parsed[1], Op: parsed[2], text: strings.TrimSpace(text)}
+	value := parsed[3]
+
+	var err error
+	if _, ok := durationMetrics[a.Metric]; ok {
+		var d time.Duration
+		d, err = time.ParseDuration(value)
+		a.Threshold = float64(d)
+	} else if rateMetrics[a.Metric] {
+		if strings.HasSuffix(value, "%") {
+			a.Threshold, err = strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+			a.Threshold /= 100
+		} else {
+			a.Threshold, err = strconv.ParseFloat(value, 64)
+		}
+	} else if a.Metric == "rps" || a.Metric == "count" {
+		a.Threshold, err = strconv.ParseFloat(value, 64)
+	} else {
+		return nil, fmt.Errorf("Unknown metric '%s' in assertion: '%s'", a.Metric, text)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if parsed[4] != "" {
+		a.Rule, err = regexp.Compile(parsed[4])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+// ParseAssertions parses ';' separated assertions
+func ParseAssertions(text string) ([]*Assertion, error) {
+	ret := make([]*Assertion, 0)
+	for _, s := range strings.Split(text, ";") {
+		if strings.TrimSpace(s) == "" {
+			continue
+		}
+		a, err := ParseAssertion(s)
+		if err != nil {
+			return ret, err
+		}
+		ret = append(ret, a)
+	}
+	return ret, nil
+}
+
+// ReadAssertions reads assertions from file, one per line, '#' starts a comment
+func ReadAssertions(filepath string) ([]*Assertion, error) {
+	ret := make([]*Assertion, 0)
+	file, err := os.Open(filepath)
+	if err != nil {
+		return ret, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		a, err := ParseAssertion(text)
+		if err != nil {
+			return ret, err
+		}
+		ret = append(ret, a)
+	}
+	return ret, scanner.Err()
+}
+
+func fraction(n uint64, total uint64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) / float64(total)
+}
+
+// Value returns assertion metric for stats
+func (a *Assertion) Value(s *Stats) float64 {
+	h := s.Latency
+	switch a.Metric {
+	case "min":
+		return float64(h.Min())
+	case "max":
+		return float64(h.Max())
+	case "mean":
+		return float64(h.Mean())
+	case "error_rate":
+		return fraction(s.Failed, h.Count())
+	case "late_rate":
+		return fraction(s.Late, h.Count())
+	case "drop_rate":
+		return fraction(s.Dropped, h.Count()+s.Dropped)
+	case "rps":
+		return s.Throughput()
+	case "count":
+		return float64(h.Count())
+	}
+	return float64(h.Quantile(durationMetrics[a.Metric]))
+}
+
+func (a *Assertion) check(value float64) bool {
+	switch a.Op {
+	case "<":
+		return value < a.Threshold
+	case "<=":
+		return value <= a.Threshold
+	case ">":
+		return value > a.Threshold
+	case ">=":
+		return value >= a.Threshold
+	}
+	return false
+}
+
+// Check evaluates assertion against summary total or matching rules.
+// Per rule assertion fails if no rule matches
+func (a *Assertion) Check(summary *Summary) []AssertionResult {
+	ret := make([]AssertionResult, 0)
+	if a.Rule == nil {
+		value := a.Value(summary.Total)
+		return append(ret, AssertionResult{a, "total", value, a.check(value)})
+	}
+
+	for _, rule := range sortedKeys(summary.Rules) {
+		if a.Rule.MatchString(rule) {
+			value := a.Value(summary.Rules[rule])
+			ret = append(ret, AssertionResult{a, rule, value, a.check(value)})
+		}
+	}
+	if len(ret) == 0 {
+		ret = append(ret, AssertionResult{a, noMatchingRule, 0, false})
+	}
+	return ret
+}
+
+// CheckAssertions evaluates all assertions, returns results and true if all passed
+func CheckAssertions(assertions []*Assertion, summary *Summary) ([]AssertionResult, bool) {
+	ret := make([]AssertionResult, 0)
+	passed := true
+	for _, a := range assertions {
+		for _, r := range a.Check(summary) {
+			passed = passed && r.Passed
+			ret = append(ret, r)
+		}
+	}
+	return ret, passed
+}
+
+// FormatValue returns human readable metric value
+func (r AssertionResult) FormatValue() string {
+	if _, ok := durationMetrics[r.Assertion.Metric]; ok {
+		return fmtDuration(time.Duration(r.Value))
+	}
+	if rateMetrics[r.Assertion.Metric] {
+		return fmt.Sprintf("%.2f%%", r.Value*100)
+	}
+	return fmt.Sprintf("%.2f", r.Value)
+}
+
+// PrintAssertions writes pass/fail report
+func PrintAssertions(w io.Writer, results []AssertionResult) {
+	for _, r := range results {
+		status := "PASS"
+		if !r.Passed {
+			status = "FAIL"
+		}
+		fmt.Fprintf(w, "%s  %-30s actual: %-12s %s\n", status, r.Assertion.text, r.FormatValue(), r.Group)
+	}
+}
+
+// SLOMonitor checks assertions periodically during the run
+// and optionally stops the run on the first breach
+type SLOMonitor struct {
+	Assertions []*Assertion
+	Interval   time.Duration
+	Abort      bool
+	// Stop is called on breach if Abort is set
+	Stop      context.CancelFunc
+	Breached  bool
+	lastCheck time.Time
+}
+
+// NewSLOMonitor returns monitor checking assertions every interval, zero interval disables checks
+func NewSLOMonitor(assertions []*Assertion, interval time.Duration, abort bool, stop context.CancelFunc) *SLOMonitor {
+	return &SLOMonitor{
+		Assertions: assertions,
+		Interval:   interval,
+		Abort:      abort,
+		Stop:       stop,
+		lastCheck:  time.Now(),
+	}
+}
+
+// Observe checks assertions if interval passed since the last check
+func (m *SLOMonitor) Observe(summary *Summary) {
+	if m.Interval <= 0 || m.Breached || len(m.Assertions) == 0 || time.Since(m.lastCheck) < m.Interval {
+		return
+	}
+	m.lastCheck = time.Now()
+
+	results, _ := CheckAssertions(m.Assertions, summary)
+	// rules with low weight may have no requests yet, it is checked at the end
+	passed := true
+	for _, r := range results {
+		passed = passed && (r.Passed || r.Group == noMatchingRule)
+	}
+	if passed {
+		return
+	}
+
+	m.Breached = true
+	fmt.Fprintf(os.Stderr, "SLO breached after %s:\n", summary.Duration().Round(time.Second))
+	PrintAssertions(os.Stderr, results)
+	if m.Abort {
+		fmt.Fprintln(os.Stderr, "Aborting the run")
+		m.Stop()
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseAssertion(t *testing.T) {
+	a, err := ParseAssertion("p99<500ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Metric != "p99" || a.Op != "<" || a.Threshold != float64(500*time.Millisecond) || a.Rule != nil {
+		t.Errorf("Not expected assertion: %v", a)
+	}
+
+	a, err = ParseAssertion(" error_rate <= 1% for ^sumSeries ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Threshold != 0.01 || a.Rule == nil || !a.Rule.MatchString("sumSeries(%s)[1h0m0s]") {
+		t.Errorf("Not expected assertion: %v", a)
+	}
+
+	for _, bad := range []string{"", "p99", "p99<", "p99<abc", "foo<1", "rps=>1", "p99<1s for ("} {
+		if _, err := ParseAssertion(bad); err == nil {
+			t.Errorf("Error should not be nil for assertion '%s'", bad)
+		}
+	}
+}
+
+func TestCheckAssertions(t *testing.T) {
+	summary := NewSummary(10)
+	start := time.Unix(1600000000, 0)
+	for i := 0; i < 100; i++ {
+		var request requestData
+		request.Rule = "a[1h0m0s]"
+		if i%2 == 0 {
+			request.Rule = "b[1h0m0s]"
+		}
+		request.Scheduled = start.Add(time.Duration(i) * 10 * time.Millisecond)
+		request.Elapsed = time.Duration(i+1) * time.Millisecond
+		request.Failed = i < 5
+		summary.Add(request)
+	}
+
+	assertions, err := ParseAssertions("p99<200ms;error_rate<10%;rps>50")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results, passed := CheckAssertions(assertions, summary); !passed || len(results) != 3 {
+		t.Errorf("Assertions should pass: %v", results)
+	}
+
+	assertions, _ = ParseAssertions("max<50ms for .;error_rate<1%")
+	results, passed := CheckAssertions(assertions, summary)
+	if passed || len(results) != 3 {
+		t.Errorf("Assertions should fail: %v", results)
+	}
+
+	// typo in rule should not pass silently
+	assertions, _ = ParseAssertions("p99<200ms for ^c")
+	results, passed = CheckAssertions(assertions, summary)
+	if passed || len(results) != 1 || results[0].Group != noMatchingRule {
+		t.Errorf("Assertion matching no rule should fail: %v", results)
+	}
+
+	// but doesn't breach during the run
+	monitor := NewSLOMonitor(assertions, time.Nanosecond, true, func() { t.Error("Run should not be stopped") })
+	monitor.lastCheck = start
+	monitor.Observe(summary)
+	if monitor.Breached {
+		t.Error("Assertion matching no rule should not breach during the run")
+	}
+}
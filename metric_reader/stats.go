@@ -0,0 +1,275 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/ifireice/metric_reader/metric_reader/backend"
+)
+
+// otherQueries is a group for queries over Summary query limit
+const otherQueries = "(other)"
+
+// Replay groups: latency observed by metric_reader, query time logged by backend
+// for the same replayed requests and per request overhead, i.e. observed minus logged
+// (zero if observed is less)
+const (
+	ReplayObserved = "observed"
+	ReplayLogged   = "logged"
+	ReplayOverhead = "overhead"
+)
+
+// Stats aggregates results of a group of requests
+type Stats struct {
+	Latency *Histogram
+	Failed  uint64
+	Late    uint64
+	Dropped uint64
+	// Canceled are requests aborted on shutdown, they are excluded from latency
+	Canceled uint64
+	Bytes    uint64
+	// First and Last are send time of the first request and finish time of the last one
+	First time.Time
+	Last  time.Time
+	// Run is stats of the whole run if s is its group, throughput of the group
+	// is computed over the run window
+	Run *Stats
+}
+
+// NewStats returns empty Stats
+func NewStats() *Stats {
+	return &Stats{Latency: NewHistogram()}
+}
+
+// Add records request result
+func (s *Stats) Add(request requestData) {
+	if s.First.IsZero() || request.Scheduled.Before(s.First) {
+		s.First = request.Scheduled
+	}
+	if finish := request.Scheduled.Add(request.Elapsed); finish.After(s.Last) {
+		s.Last = finish
+	}
+
+	if request.Dropped {
+		s.Dropped++
+		return
+	}
+	if request.Status == backend.Canceled {
+		s.Canceled++
+		return
+	}
+	if request.Late {
+		s.Late++
+	}
+	if request.Failed {
+		s.Failed++
+	}
+	s.Bytes += uint64(request.Bytes)
+	s.Latency.Record(request.Elapsed)
+}
+
+// Merge adds other stats
+func (s *Stats) Merge(other *Stats) {
+	s.Failed += other.Failed
+	s.Late += other.Late
+	s.Dropped += other.Dropped
+	s.Canceled += other.Canceled
+	s.Bytes += other.Bytes
+	s.Latency.Merge(other.Latency)
+	if s.First.IsZero() || (!other.First.IsZero() && other.First.Before(s.First)) {
+		s.First = other.First
+	}
+	if other.Last.After(s.Last) {
+		s.Last = other.Last
+	}
+}
+
+// Throughput returns completed requests per second between first and last request of the run,
+// zero if less than 2 requests are completed
+func (s *Stats) Throughput() float64 {
+	window := s
+	if s.Run != nil {
+		window = s.Run
+	}
+	seconds := window.Last.Sub(window.First).Seconds()
+	if seconds <= 0 || s.Latency.Count() < 2 {
+		return 0
+	}
+	return float64(s.Latency.Count()) / seconds
+}
+
+// Summary aggregates stats overall, per load stage, per outcome class, per cache group (cold/warm),
+// per replay group (observed/logged), per rule template and per query.
+// Number of tracked queries is limited, so memory is bounded on endless runs.
+// Requests of warm-up stage are only counted
+type Summary struct {
+	Start      time.Time
+	End        time.Time
+	Total      *Stats
+	Stages     map[string]*Stats
+	Classes    map[string]*Stats
+	Cache      map[string]*Stats
+	Replay     map[string]*Stats
+	Phases     map[string]*Histogram
+	Rules      map[string]*Stats
+	Queries    map[string]*Stats
+	MaxQueries int
+	Warmup     uint64
+}
+
+// NewSummary returns empty summary tracking at most maxQueries queries
+func NewSummary(maxQueries int) *Summary {
+	return &Summary{
+		Start:      time.Now(),
+		Total:      NewStats(),
+		Stages:     make(map[string]*Stats),
+		Classes:    make(map[string]*Stats),
+		Cache:      make(map[string]*Stats),
+		Replay:     make(map[string]*Stats),
+		Phases:     make(map[string]*Histogram),
+		Rules:      make(map[string]*Stats),
+		Queries:    make(map[string]*Stats),
+		MaxQueries: maxQueries,
+	}
+}
+
+// getStats returns stats of group key, new group is a part of run (nil for time slices like stages)
+func getStats(m map[string]*Stats, key string, run *Stats) *Stats {
+	s, ok := m[key]
+	if !ok {
+		s = NewStats()
+		s.Run = run
+		m[key] = s
+	}
+	return s
+}
+
+// Add records request result
+func (s *Summary) Add(request requestData) {
+	if request.Stage == warmupStage {
+		s.Warmup++
+		return
+	}
+
+	s.Total.Add(request)
+	getStats(s.Stages, request.Stage, nil).Add(request)
+	if !request.Dropped && request.Status != backend.Canceled {
+		getStats(s.Classes, request.Status, s.Total).Add(request)
+		s.addPhases(request.Timings)
+		if request.Logged > 0 {
+			getStats(s.Replay, ReplayObserved, s.Total).Add(request)
+			logged := request
+			logged.Elapsed = request.Logged
+			getStats(s.Replay, ReplayLogged, s.Total).Add(logged)
+			overhead := request
+			overhead.Elapsed = request.Elapsed - request.Logged
+			getStats(s.Replay, ReplayOverhead, s.Total).Add(overhead)
+		}
+	}
+	if request.Cache != "" {
+		getStats(s.Cache, request.Cache, s.Total).Add(request)
+	}
+	getStats(s.Rules, request.Rule, s.Total).Add(request)
+
+	query := request.MetricName
+	if _, ok := s.Queries[query]; !ok && len(s.Queries) >= s.MaxQueries {
+		query = otherQueries
+	}
+	getStats(s.Queries, query, s.Total).Add(request)
+}
+
+// addPhases records request phases, connection phases are recorded only if happened
+func (s *Summary) addPhases(timings Timings) {
+	for _, phase := range Phases {
+		d := timings.Get(phase)
+		if d == 0 && (phase == PhaseDNS || phase == PhaseConnect || phase == PhaseTLS) {
+			continue
+		}
+		h, ok := s.Phases[phase]
+		if !ok {
+			h = NewHistogram()
+			s.Phases[phase] = h
+		}
+		h.Record(d)
+	}
+}
+
+// Duration returns wall time of the run
+func (s *Summary) Duration() time.Duration {
+	end := s.End
+	if end.IsZero() {
+		end = time.Now()
+	}
+	return end.Sub(s.Start)
+}
+
+func sortedKeys(m map[string]*Stats) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func printStatsHeader(w io.Writer) {
+	fmt.Fprintf(w, "%-12s %8s %8s %8s %8s %10s %10s %10s %10s %10s %10s %10s %10s %10s %9s  %s\n",
+		"", "count", "failed", "late", "dropped", "min", "p50", "p90", "p95", "p99", "p99.9", "max", "mean", "stddev", "rps", "name")
+}
+
+func printStats(w io.Writer, group string, name string, stats *Stats) {
+	h := stats.Latency
+	fmt.Fprintf(w, "%-12s %8d %8d %8d %8d %10s %10s %10s %10s %10s %10s %10s %10s %10s %9.2f  %s\n",
+		group, h.Count(), stats.Failed, stats.Late, stats.Dropped,
+		fmtDuration(h.Min()), fmtDuration(h.Quantile(0.5)), fmtDuration(h.Quantile(0.9)),
+		fmtDuration(h.Quantile(0.95)), fmtDuration(h.Quantile(0.99)), fmtDuration(h.Quantile(0.999)),
+		fmtDuration(h.Max()), fmtDuration(h.Mean()), fmtDuration(h.StdDev()),
+		stats.Throughput(), name)
+}
+
+func fmtDuration(d time.Duration) string {
+	return d.Round(time.Microsecond).String()
+}
+
+// Print writes human readable summary
+func (s *Summary) Print(w io.Writer) {
+	fmt.Fprintf(w, "Duration: %s\n", s.Duration().Round(time.Millisecond))
+	if count := s.Total.Latency.Count(); count > 0 {
+		fmt.Fprintf(w, "Received: %d bytes, %d bytes per request\n", s.Total.Bytes, s.Total.Bytes/count)
+	}
+	if s.Warmup > 0 {
+		fmt.Fprintf(w, "Warm-up requests excluded: %d\n", s.Warmup)
+	}
+	if s.Total.Canceled > 0 {
+		fmt.Fprintf(w, "Canceled on shutdown: %d\n", s.Total.Canceled)
+	}
+	printStatsHeader(w)
+	printStats(w, "total", "", s.Total)
+	if len(s.Stages) > 1 {
+		for _, k := range sortedKeys(s.Stages) {
+			printStats(w, "stage", k, s.Stages[k])
+		}
+	}
+	for _, k := range sortedKeys(s.Classes) {
+		printStats(w, "class", k, s.Classes[k])
+	}
+	for _, k := range sortedKeys(s.Cache) {
+		printStats(w, "cache", k, s.Cache[k])
+	}
+	for _, k := range sortedKeys(s.Replay) {
+		printStats(w, "replay", k, s.Replay[k])
+	}
+	for _, phase := range Phases {
+		if h, ok := s.Phases[phase]; ok {
+			printStats(w, "phase", phase, &Stats{Latency: h})
+		}
+	}
+	for _, k := range sortedKeys(s.Rules) {
+		printStats(w, "rule", k, s.Rules[k])
+	}
+	for _, k := range sortedKeys(s.Queries) {
+		printStats(w, "query", k, s.Queries[k])
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestGroupThroughput(t *testing.T) {
+	summary := NewSummary(10)
+	start := time.Unix(1600000000, 0)
+	add := func(rule string, at time.Duration) {
+		var request requestData
+		request.Rule = rule
+		request.Stage = "main"
+		request.Status = "ok"
+		request.Scheduled = start.Add(at)
+		request.Elapsed = 10 * time.Millisecond
+		summary.Add(request)
+	}
+	add("a", 0)
+	add("b", time.Second)
+	add("b", 5*time.Second)
+	add("b", 10*time.Second-10*time.Millisecond)
+
+	if rps := summary.Total.Throughput(); rps != 0.4 {
+		t.Errorf("Not expected total rps: %g", rps)
+	}
+	// groups are measured over the run window, single request has no rate
+	if rps := summary.Rules["b"].Throughput(); math.Abs(rps-0.3) > 1e-9 {
+		t.Errorf("Not expected rule rps: %g", rps)
+	}
+	if rps := summary.Rules["a"].Throughput(); rps != 0 {
+		t.Errorf("Not expected rps of single request: %g", rps)
+	}
+	if rps := summary.Stages["main"].Throughput(); rps != 0.4 {
+		t.Errorf("Not expected stage rps: %g", rps)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/ifireice/metric_reader/metric_reader/backend"
+)
+
+// Template placeholders, e.g. 'divideSeries(seriesByTag({{tag "dc"}},'name=errors'),{{series}})'
+const (
+	// SeriesPlaceholder is a random series, seriesN (series2, series3 ...) are other independent series
+	SeriesPlaceholder = "series"
+	// TagPlaceholder is a tag matcher with random value, the same within query: {{tag "dc"}}
+	TagPlaceholder = "tag"
+	// ValuePlaceholder is a random tag value, the same as of {{tag}} with this name: {{value "dc"}}
+	ValuePlaceholder = "value"
+	// IntervalPlaceholder is an interval between data points of query computed by backend, like Grafana $__interval
+	IntervalPlaceholder = "interval"
+	// RangePlaceholder is a query period, like Grafana $__range
+	RangePlaceholder = "range"
+)
+
+var placeholderRe = regexp.MustCompile(`\{\{\s*([a-z]+)([0-9]*)(?:\s+"([^"]*)")?\s*\}\}`)
+
+// IsNamedTemplate returns true if template uses {{placeholders}}, otherwise it is a '%s' template
+func IsNamedTemplate(template string) bool {
+	return strings.Contains(template, "{{")
+}
+
+// ValidateTemplate checks template placeholders
+func ValidateTemplate(template string) error {
+	if !IsNamedTemplate(template) {
+		return nil
+	}
+	rest := placeholderRe.ReplaceAllString(template, "")
+	if strings.Contains(rest, "{{") || strings.Contains(rest, "}}") {
+		return fmt.Errorf("Cant parse placeholders of template '%s'", template)
+	}
+
+	for _, m := range placeholderRe.FindAllStringSubmatch(template, -1) {
+		name, n, arg := m[1], m[2], m[3]
+		switch name {
+		case SeriesPlaceholder:
+			if arg != "" {
+				return fmt.Errorf("Placeholder '%s' has no arguments in template '%s'", m[0], template)
+			}
+			continue
+		case TagPlaceholder, ValuePlaceholder:
+			if arg == "" {
+				return fmt.Errorf("Placeholder '%s' needs tag name in template '%s'", m[0], template)
+			}
+		case IntervalPlaceholder, RangePlaceholder:
+			if arg != "" {
+				return fmt.Errorf("Placeholder '%s' has no arguments in template '%s'", m[0], template)
+			}
+		default:
+			return fmt.Errorf("Unknown placeholder '%s' in template '%s'", m[0], template)
+		}
+		if n != "" {
+			return fmt.Errorf("Unknown placeholder '%s' in template '%s'", m[0], template)
+		}
+	}
+	return nil
+}
+
+// TemplateVars fills placeholders of one query.
+// Every series and tag is picked once, so repeated placeholders get the same value
+type TemplateVars struct {
+	Series   SeriesSource
+	Backend  backend.Backend
+	Range    time.Duration
+	Interval time.Duration
+	series   map[string]string
+	values   map[string]string
+}
+
+// NewTemplateVars returns empty vars of query
+func NewTemplateVars(series SeriesSource, b backend.Backend, period time.Duration, interval time.Duration) *TemplateVars {
+	return &TemplateVars{
+		Series:   series,
+		Backend:  b,
+		Range:    period,
+		Interval: interval,
+		series:   make(map[string]string),
+		values:   make(map[string]string),
+	}
+}
+
+func (v *TemplateVars) getSeries(name string) (string, error) {
+	if s, ok := v.series[name]; ok {
+		return s, nil
+	}
+	s, err := v.Series.RandomSeries()
+	if err != nil {
+		return "", err
+	}
+	v.series[name] = s
+	return s, nil
+}
+
+func (v *TemplateVars) getValue(tag string) (string, error) {
+	if value, ok := v.values[tag]; ok {
+		return value, nil
+	}
+	value, err := v.Series.RandomTagValue(tag)
+	if err != nil {
+		return "", err
+	}
+	v.values[tag] = value
+	return value, nil
+}
+
+// fmtSeconds formats duration as whole seconds, understood by both Graphite and PromQL
+func fmtSeconds(d time.Duration) string {
+	seconds := int64(d.Seconds())
+	if seconds < 1 {
+		seconds = 1
+	}
+	return fmt.Sprintf("%ds", seconds)
+}
+
+// ExpandTemplate returns query of template. '%s' templates get a single series
+func ExpandTemplate(template string, vars *TemplateVars) (string, error) {
+	if !IsNamedTemplate(template) {
+		metric, err := vars.getSeries(SeriesPlaceholder)
+		if err != nil {
+			return "", err
+		}
+		return Template2Metric(template, metric), nil
+	}
+
+	var err error
+	ret := placeholderRe.ReplaceAllStringFunc(template, func(placeholder string) string {
+		if err != nil {
+			return ""
+		}
+		m := placeholderRe.FindStringSubmatch(placeholder)
+		name, arg := m[1], m[3]
+
+		var value string
+		switch name {
+		case SeriesPlaceholder:
+			value, err = vars.getSeries(name + m[2])
+		case TagPlaceholder:
+			value, err = vars.getValue(arg)
+			value = vars.Backend.TagMatcher(arg, value)
+		case ValuePlaceholder:
+			value, err = vars.getValue(arg)
+		case IntervalPlaceholder:
+			value = fmtSeconds(vars.Interval)
+		case RangePlaceholder:
+			value = fmtSeconds(vars.Range)
+		default:
+			err = fmt.Errorf("Unknown placeholder '%s'", placeholder)
+		}
+		return value
+	})
+	return ret, err
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ifireice/metric_reader/metric_reader/carbon"
+	"github.com/ifireice/metric_reader/metric_reader/prometheus"
+)
+
+// testSource returns series and values numbered in order of requests
+type testSource struct {
+	n int
+}
+
+func (s *testSource) RandomSeries() (string, error) {
+	s.n++
+	return fmt.Sprintf("'name=s%d'", s.n), nil
+}
+
+func (s *testSource) RandomTagValue(tag string) (string, error) {
+	s.n++
+	return fmt.Sprintf("%s%d", tag, s.n), nil
+}
+
+func TestExpandTemplate(t *testing.T) {
+	check := func(template string, expected string) {
+		vars := NewTemplateVars(&testSource{}, carbon.New(""), 24*time.Hour, time.Minute)
+		query, err := ExpandTemplate(template, vars)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if query != expected {
+			t.Errorf("ExpandTemplate('%s'): '%s' != '%s'", template, query, expected)
+		}
+	}
+
+	check("sumSeries(%s)", "sumSeries('name=s1')")
+	check("divideSeries(seriesByTag({{series}}),seriesByTag({{series2}}))", "divideSeries(seriesByTag('name=s1'),seriesByTag('name=s2'))")
+	check("asPercent(seriesByTag({{tag \"dc\"}},'name=err'),seriesByTag({{ tag \"dc\" }},'name=all'))",
+		"asPercent(seriesByTag('dc=dc1','name=err'),seriesByTag('dc=dc1','name=all'))")
+	check("aliasByTags(seriesByTag('dc={{value \"dc\"}}'),'dc') 100%", "aliasByTags(seriesByTag('dc=dc1'),'dc') 100%")
+	check("summarize({{series}},'{{interval}}') over {{range}}", "summarize('name=s1','60s') over 86400s")
+
+	vars := NewTemplateVars(&testSource{}, prometheus.New(""), time.Hour, time.Minute)
+	query, _ := ExpandTemplate(`sum(rate(up{ {{tag "job"}} }[{{interval}}]))`, vars)
+	if query != `sum(rate(up{ job="job1" }[60s]))` {
+		t.Errorf("Not expected PromQL query: %s", query)
+	}
+}
+
+func TestValidateTemplate(t *testing.T) {
+	for _, good := range []string{"%s", "{{series}}", "{{series3}}", `{{tag "dc"}}`, `{{value "dc"}}`, "{{interval}}{{range}}"} {
+		if err := ValidateTemplate(good); err != nil {
+			t.Errorf("Template '%s': %s", good, err)
+		}
+	}
+	for _, bad := range []string{"{{serie}}", "{{tag}}", `{{value ""}}`, `{{series "dc"}}`, "{{range2}}", "{{series", `{{interval "1m"}}`} {
+		if err := ValidateTemplate(bad); err == nil {
+			t.Errorf("Error should not be nil for template '%s'", bad)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// Request phases names
+const (
+	PhaseWait     = "wait"
+	PhaseDNS      = "dns"
+	PhaseConnect  = "connect"
+	PhaseTLS      = "tls"
+	PhaseTTFB     = "ttfb"
+	PhaseDownload = "download"
+)
+
+// Phases is an ordered list of request phases names
+var Phases = []string{PhaseWait, PhaseDNS, PhaseConnect, PhaseTLS, PhaseTTFB, PhaseDownload}
+
+// Timings is a breakdown of request time by phases, phases don't overlap.
+// DNS, Connect and TLS are zero for requests over reused connections
+type Timings struct {
+	Wait     time.Duration // from intended send time to actual send
+	DNS      time.Duration
+	Connect  time.Duration
+	TLS      time.Duration
+	TTFB     time.Duration // from the request written to the first response byte
+	Download time.Duration // from the first response byte to the end of body
+}
+
+// Get returns phase duration by name
+func (t Timings) Get(phase string) time.Duration {
+	switch phase {
+	case PhaseWait:
+		return t.Wait
+	case PhaseDNS:
+		return t.DNS
+	case PhaseConnect:
+		return t.Connect
+	case PhaseTLS:
+		return t.TLS
+	case PhaseTTFB:
+		return t.TTFB
+	case PhaseDownload:
+		return t.Download
+	}
+	return 0
+}
+
+// tracer collects phases timings of a single request
+type tracer struct {
+	mu           sync.Mutex
+	timings      Timings
+	start        time.Time
+	dnsStart     time.Time
+	connectStart time.Time
+	tlsStart     time.Time
+	wroteRequest time.Time
+	firstByte    time.Time
+}
+
+func newTracer(start time.Time) *tracer {
+	return &tracer{start: start}
+}
+
+// withTrace returns request with client trace filling tracer timings
+func (t *tracer) withTrace(req *http.Request) *http.Request {
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			t.mu.Lock()
+			t.dnsStart = time.Now()
+			t.mu.Unlock()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			t.mu.Lock()
+			t.timings.DNS = time.Since(t.dnsStart)
+			t.mu.Unlock()
+		},
+		ConnectStart: func(string, string) {
+			t.mu.Lock()
+			if t.connectStart.IsZero() {
+				t.connectStart = time.Now()
+			}
+			t.mu.Unlock()
+		},
+		ConnectDone: func(string, string, error) {
+			t.mu.Lock()
+			t.timings.Connect = time.Since(t.connectStart)
+			t.mu.Unlock()
+		},
+		TLSHandshakeStart: func() {
+			t.mu.Lock()
+			t.tlsStart = time.Now()
+			t.mu.Unlock()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			t.mu.Lock()
+			t.timings.TLS = time.Since(t.tlsStart)
+			t.mu.Unlock()
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			t.mu.Lock()
+			t.wroteRequest = time.Now()
+			t.mu.Unlock()
+		},
+		GotFirstResponseByte: func() {
+			t.mu.Lock()
+			t.firstByte = time.Now()
+			// connection setup is not a part of ttfb
+			sent := t.wroteRequest
+			if sent.IsZero() {
+				sent = t.start
+			}
+			t.timings.TTFB = t.firstByte.Sub(sent)
+			t.mu.Unlock()
+		},
+	}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+}
+
+// done finishes tracing after body is read and returns timings
+func (t *tracer) done() Timings {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.firstByte.IsZero() {
+		t.timings.Download = time.Since(t.firstByte)
+	}
+	return t.timings
+}
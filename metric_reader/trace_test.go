@@ -0,0 +1,52 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTracer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		time.Sleep(10 * time.Millisecond)
+		w.Write([]byte("[]"))
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: &http.Transport{}}
+	get := func() (Timings, time.Duration) {
+		req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		start := time.Now()
+		trace := newTracer(start)
+		resp, err := client.Do(trace.withTrace(req))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return trace.done(), time.Since(start)
+	}
+
+	// new connection
+	timings, elapsed := get()
+	if timings.Connect <= 0 || timings.TTFB < 20*time.Millisecond || timings.Download < 10*time.Millisecond {
+		t.Errorf("Not expected timings: %+v", timings)
+	}
+	if sum := timings.DNS + timings.Connect + timings.TLS + timings.TTFB + timings.Download; sum > elapsed {
+		t.Errorf("Phases overlap: %+v, sum %s > elapsed %s", timings, sum, elapsed)
+	}
+
+	// reused connection
+	timings, elapsed = get()
+	if timings.Connect != 0 || timings.TTFB < 20*time.Millisecond || timings.TTFB+timings.Download > elapsed {
+		t.Errorf("Not expected timings of reused connection: %+v, elapsed %s", timings, elapsed)
+	}
+}
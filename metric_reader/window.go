@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// Time window strategies
+const (
+	// NowWindow is 'last period ending now'
+	NowWindow = "now"
+	// UniformWindow ends at uniformly random time within -period
+	UniformWindow = "uniform"
+	// RecentWindow ends before now by exponentially distributed offset with given mean
+	RecentWindow = "recent"
+	// AlignedWindow is now-anchored with from and until truncated to step
+	AlignedWindow = "aligned"
+	// RetentionWindow straddles retention boundary, i.e. starts before and ends after now minus retention
+	RetentionWindow = "retention"
+)
+
+// TimeWindow picks query time range: 'now', 'uniform', 'recent:mean' (e.g. recent:1h),
+// 'aligned:step' (e.g. aligned:1h) OR 'retention:age' (e.g. retention:168h)
+type TimeWindow struct {
+	Name  string
+	Param time.Duration
+}
+
+// ParseTimeWindow parses time window spec
+func ParseTimeWindow(spec string) (*TimeWindow, error) {
+	parts := strings.SplitN(spec, ":", 2)
+	w := &TimeWindow{Name: parts[0]}
+	switch w.Name {
+	case NowWindow, UniformWindow:
+		if len(parts) != 1 {
+			return nil, fmt.Errorf("Time window '%s' has no parameters", spec)
+		}
+		return w, nil
+	case RecentWindow, AlignedWindow, RetentionWindow:
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Time window '%s' needs duration parameter", spec)
+		}
+		var err error
+		w.Param, err = time.ParseDuration(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		if w.Param <= 0 {
+			return nil, fmt.Errorf("Time window '%s' needs positive duration", spec)
+		}
+		return w, nil
+	}
+	return nil, fmt.Errorf("Unknown time window '%s'", spec)
+}
+
+// String returns time window spec
+func (w *TimeWindow) String() string {
+	if w.Param > 0 {
+		return fmt.Sprintf("%s:%s", w.Name, w.Param)
+	}
+	return w.Name
+}
+
+// Range returns query range of period. Until is never after now
+// and range ends within maxPeriod from now if it fits
+func (w *TimeWindow) Range(now time.Time, maxPeriod time.Duration, period time.Duration, r *rand.Rand) (time.Time, time.Time) {
+	now = now.Truncate(time.Second)
+	// max offset of until from now keeping from within maxPeriod
+	maxOffset := maxPeriod - period
+	if maxOffset < 0 {
+		maxOffset = 0
+	}
+
+	until := now
+	switch w.Name {
+	case UniformWindow:
+		if maxOffset > 0 {
+			until = now.Add(-time.Duration(r.Int63n(int64(maxOffset))))
+		}
+	case RecentWindow:
+		offset := time.Duration(r.ExpFloat64() * float64(w.Param))
+		if offset > maxOffset {
+			offset = maxOffset
+		}
+		until = now.Add(-offset)
+	case AlignedWindow:
+		until = now.Truncate(w.Param)
+		from := until.Add(-period).Truncate(w.Param)
+		return from, until
+	case RetentionWindow:
+		until = now.Add(-w.Param).Add(period / 2)
+		if until.After(now) {
+			until = now
+		}
+	}
+	until = until.Truncate(time.Second)
+	return until.Add(-period), until
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestParseTimeWindow(t *testing.T) {
+	for _, good := range []string{"now", "uniform", "recent:1h0m0s", "aligned:1m0s", "retention:168h0m0s"} {
+		w, err := ParseTimeWindow(good)
+		if err != nil {
+			t.Errorf("Window '%s': %s", good, err)
+			continue
+		}
+		if w.String() != good {
+			t.Errorf("Not expected window: %s != %s", w, good)
+		}
+	}
+	for _, bad := range []string{"", "now:1h", "recent", "recent:x", "aligned:0s", "retention:-1h", "future"} {
+		if _, err := ParseTimeWindow(bad); err == nil {
+			t.Errorf("Error should not be nil for window '%s'", bad)
+		}
+	}
+}
+
+func TestTimeWindowRange(t *testing.T) {
+	now := time.Date(2020, 9, 1, 12, 34, 56, 0, time.UTC)
+	period := 10 * time.Minute
+	maxPeriod := 24 * time.Hour
+	r := rand.New(rand.NewSource(1))
+
+	for _, spec := range []string{"now", "uniform", "recent:1h", "aligned:1m", "retention:2h"} {
+		w, _ := ParseTimeWindow(spec)
+		for i := 0; i < 1000; i++ {
+			from, until := w.Range(now, maxPeriod, period, r)
+			if until.After(now) {
+				t.Fatalf("Window %s: until %s is after now", w, until)
+			}
+			if from.Before(now.Add(-maxPeriod)) {
+				t.Fatalf("Window %s: from %s is out of max period", w, from)
+			}
+			if until.Sub(from) < period {
+				t.Fatalf("Window %s: range %s is less than period", w, until.Sub(from))
+			}
+		}
+	}
+
+	w, _ := ParseTimeWindow("now")
+	if from, until := w.Range(now, maxPeriod, period, r); !until.Equal(now) || !from.Equal(now.Add(-period)) {
+		t.Errorf("Not expected now range: %s - %s", from, until)
+	}
+
+	w, _ = ParseTimeWindow("aligned:1h")
+	from, until := w.Range(now, maxPeriod, 90*time.Minute, r)
+	if !until.Equal(time.Date(2020, 9, 1, 12, 0, 0, 0, time.UTC)) || !from.Equal(time.Date(2020, 9, 1, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("Not expected aligned range: %s - %s", from, until)
+	}
+
+	w, _ = ParseTimeWindow("retention:2h")
+	from, until = w.Range(now, maxPeriod, period, r)
+	if boundary := now.Add(-2 * time.Hour); !from.Before(boundary) || !until.After(boundary) {
+		t.Errorf("Range %s - %s should straddle retention boundary %s", from, until, boundary)
+	}
+
+	// period longer than max period ends now
+	w, _ = ParseTimeWindow("uniform")
+	if _, until := w.Range(now, time.Hour, 2*time.Hour, r); !until.Equal(now) {
+		t.Errorf("Not expected until %s", until)
+	}
+}